@@ -1,55 +1,513 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/nebojsaj1726/proxy-pool/core"
 )
 
-func ListProxiesHandler(pool core.Pooler) http.HandlerFunc {
+func ListProxiesHandler(pool core.Reporter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type proxyInfo struct {
-			URL      string `json:"url"`
-			Alive    bool   `json:"alive"`
-			LastTest string `json:"last_test"`
+			URL      string            `json:"url"`
+			Alive    bool              `json:"alive"`
+			LastTest string            `json:"last_test"`
+			Tags     map[string]string `json:"tags"`
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		alive := pool.AliveProxies()
-		resp := make([]proxyInfo, len(alive))
-		for i, p := range alive {
-			snap := p.Snapshot()
-			resp[i] = proxyInfo{
-				URL:      snap.URL,
-				Alive:    snap.Alive,
-				LastTest: snap.LastTest.Format("15:04:05"),
+		snap := pool.Snapshot()
+		resp := make([]proxyInfo, 0, len(snap.Alive))
+		for _, s := range snap.Stats {
+			if !s.Alive || s.Probation {
+				continue
 			}
+			lastTest, _ := time.Parse(time.RFC3339, s.LastTest)
+			resp = append(resp, proxyInfo{
+				URL:      s.URL,
+				Alive:    s.Alive,
+				LastTest: lastTest.Format("15:04:05"),
+				Tags:     s.Tags,
+			})
 		}
 
+		w.Header().Set("Content-Type", "application/json")
+		setSnapshotVersion(w, snap)
 		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
 
-func AllocateProxyHandler(pool core.Pooler) http.HandlerFunc {
+// AllocateProxyHandler hands out a proxy matching the criteria given as
+// a JSON body or query parameters (see allocationInput), e.g.
+// ?tag=country:us&max_latency_ms=800&exclude=http://1.2.3.4:8080.
+// With a session key the same proxy is returned for every call with that
+// key while it stays alive; session_ttl (seconds) controls how long an
+// idle binding is kept. With count, up to that many distinct proxies
+// spread across subnets are returned as a list.
+func AllocateProxyHandler(pool core.Allocator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		var input allocationInput
+		if err := decodeBody(r, &input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := input.applyQuery(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := input.request()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if input.Count > 0 {
+			proxies, err := pool.AllocateBatch(r.Context(), req, input.Count)
+			if err != nil {
+				writeAllocError(w, err)
+				return
+			}
+			urls := make([]string, len(proxies))
+			for i, p := range proxies {
+				urls[i] = p.URL
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string][]string{
+				"allocated": urls,
+			})
+			return
+		}
+
+		alloc, err := pool.AllocateWith(r.Context(), req)
+		if err != nil {
+			writeAllocError(w, err)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		proxy, err := pool.Allocate()
+		if req.SessionKey == "" {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"allocated": alloc.Proxy.URL,
+			})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"allocated": alloc.Proxy.URL,
+			"session":   req.SessionKey,
+			"failover":  alloc.Failover,
+		})
+	}
+}
+
+// writeAllocError maps allocation failures to HTTP errors. Criteria no
+// proxy in the pool can satisfy are the caller's problem (422); an empty,
+// dead, busy or throttled pool is a 503. Rate limited responses carry a
+// Retry-After hint in seconds.
+func writeAllocError(w http.ResponseWriter, err error) {
+	var rl *core.RateLimitError
+	switch {
+	case errors.Is(err, core.ErrNoMatch):
+		http.Error(w, "no proxy matches the requested criteria", http.StatusUnprocessableEntity)
+	case errors.As(err, &rl):
+		secs := int(math.Ceil(rl.RetryAfter.Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		http.Error(w, "all proxies rate limited", http.StatusServiceUnavailable)
+	case errors.Is(err, core.ErrAtCapacity):
+		http.Error(w, "all proxies busy", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "no alive proxies", http.StatusServiceUnavailable)
+	}
+}
+
+func ListSessionsHandler(pool core.SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pool.Sessions())
+	}
+}
+
+func DeleteSessionHandler(pool core.SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := pool.DeleteSession(r.PathValue("key")); err != nil {
+			if errors.Is(err, core.ErrSessionNotFound) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to delete session", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AcquireLeaseHandler leases a proxy. The optional JSON body takes
+// ttl_seconds plus the same selection criteria as /allocate; sessions
+// don't apply to leases.
+func AcquireLeaseHandler(pool core.Allocator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			TTLSeconds int `json:"ttl_seconds"`
+			allocationInput
+		}
+		if err := decodeBody(r, &input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if input.TTLSeconds < 0 {
+			http.Error(w, "ttl_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		if err := input.applyQuery(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := input.request()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lease, err := pool.AcquireWith(r.Context(), req, time.Duration(input.TTLSeconds)*time.Second)
 		if err != nil {
-			http.Error(w, "no alive proxies", http.StatusServiceUnavailable)
+			writeAllocError(w, err)
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
 		_ = json.NewEncoder(w).Encode(map[string]string{
-			"allocated": proxy.URL,
+			"lease_id":    lease.ID,
+			"proxy":       lease.Proxy.URL,
+			"acquired_at": lease.AcquiredAt.Format(time.RFC3339),
+			"expires_at":  lease.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+}
+
+// outcomeInput is the JSON shape clients use to report how a proxy behaved.
+type outcomeInput struct {
+	Success    *bool  `json:"success"`
+	LatencyMS  int    `json:"latency_ms"`
+	ErrorClass string `json:"error_class"`
+	Domain     string `json:"domain"`
+}
+
+func (in outcomeInput) outcome() core.Outcome {
+	return core.Outcome{
+		Success:    *in.Success,
+		LatencyMS:  in.LatencyMS,
+		ErrorClass: in.ErrorClass,
+		Domain:     in.Domain,
+	}
+}
+
+// ReleaseLeaseHandler releases a lease. The body is optional; when it
+// carries an outcome ({"success": false, "error_class": "banned"}) the
+// result is fed back into the proxy's score.
+func ReleaseLeaseHandler(pool core.Allocator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input outcomeInput
+		if err := decodeBody(r, &input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := r.PathValue("id")
+		var err error
+		if input.Success != nil {
+			err = pool.ReleaseWithOutcome(id, input.outcome())
+		} else {
+			err = pool.Release(id)
+		}
+		if err != nil {
+			if errors.Is(err, core.ErrLeaseNotFound) {
+				http.Error(w, "lease not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to release lease", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ReportHandler(pool core.Allocator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Proxy string `json:"proxy"`
+			outcomeInput
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "invalid input", http.StatusBadRequest)
+			return
+		}
+		if input.Proxy == "" || input.Success == nil {
+			http.Error(w, "proxy and success are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := pool.Report(input.Proxy, input.outcome()); err != nil {
+			if errors.Is(err, core.ErrProxyNotFound) {
+				http.Error(w, "proxy not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to record report", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DomainScoresHandler lists the per-target-domain scores of the proxy
+// named by the proxy query parameter.
+func DomainScoresHandler(pool core.Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxyURL := r.URL.Query().Get("proxy")
+		if proxyURL == "" {
+			http.Error(w, "proxy is required", http.StatusBadRequest)
+			return
+		}
+
+		scores, err := pool.DomainScores(proxyURL)
+		if err != nil {
+			if errors.Is(err, core.ErrProxyNotFound) {
+				http.Error(w, "proxy not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "failed to load domain scores", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"proxy":   proxyURL,
+			"domains": scores,
+		})
+	}
+}
+
+// TiersHandler reports the health of each proxy tier as of the last
+// health check, and the recent failover and failback events.
+func TiersHandler(pool core.Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"tiers":  pool.Tiers(),
+			"events": pool.TierEvents(),
+		})
+	}
+}
+
+// defaultDrainTimeout is how long DELETE /proxies/{id} waits for the
+// proxy's leases to be released unless drain_timeout says otherwise.
+const defaultDrainTimeout = 30 * time.Second
+
+// AddProxyHandler adds a proxy at runtime. The proxy is health-checked
+// before the response is written, which carries its stats.
+func AddProxyHandler(pool core.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input proxyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "invalid input", http.StatusBadRequest)
+			return
+		}
+		if input.URL == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
+			return
+		}
+
+		proxy, err := pool.Add(input.config())
+		if err != nil {
+			writeProxyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(pool.Stats(proxy))
+	}
+}
+
+// UpdateProxyHandler changes max_concurrent, tier, expires_at, rate_limit
+// or tags of the proxy with the given ID. The URL can't be changed;
+// remove the proxy and add a new one instead.
+func UpdateProxyHandler(pool core.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input proxyInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "invalid input", http.StatusBadRequest)
+			return
+		}
+		if input.URL != "" {
+			http.Error(w, "url can't be changed", http.StatusBadRequest)
+			return
+		}
+
+		proxy, err := pool.Update(r.PathValue("id"), input.update())
+		if err != nil {
+			writeProxyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pool.Stats(proxy))
+	}
+}
+
+// RemoveProxyHandler removes the proxy with the given ID. It waits up to
+// drain_timeout seconds (default 30) for the proxy's leases to be
+// released and reports how many were still active.
+func RemoveProxyHandler(pool core.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := defaultDrainTimeout
+		if v := r.URL.Query().Get("drain_timeout"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid drain_timeout %q", v), http.StatusBadRequest)
+				return
+			}
+			timeout = time.Duration(n) * time.Second
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		id := r.PathValue("id")
+		active, err := pool.Remove(ctx, id)
+		if err != nil {
+			writeProxyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":            id,
+			"drained":       active == 0,
+			"active_leases": active,
 		})
 	}
 }
 
-func StatsHandler(pool core.Pooler) http.Handler {
+// maxImportSize caps the body of POST /proxies/import.
+const maxImportSize = 10 << 20
+
+// ImportProxiesHandler bulk-adds the proxies of a provider list sent as
+// the request body (plain lines, CSV or JSON, see core.ParseProxyList).
+// Query parameters: format (auto, lines, csv, json), scheme (default
+// http), source, a label stored as the proxies' source tag, and
+// expires_at, the expiry of entries that don't carry their own.
+func ImportProxiesHandler(pool core.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			http.Error(w, "proxy list too large or unreadable", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		q := r.URL.Query()
+		var expiresAt time.Time
+		if s := q.Get("expires_at"); s != "" {
+			if expiresAt, err = core.ParseExpiry(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		res, err := pool.Import(data, core.ImportOptions{
+			Format:        q.Get("format"),
+			DefaultScheme: q.Get("scheme"),
+			Source:        q.Get("source"),
+			ExpiresAt:     expiresAt,
+		})
+		if err != nil {
+			writeProxyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func writeProxyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidProxy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, core.ErrProxyExists):
+		http.Error(w, "proxy already exists", http.StatusConflict)
+	case errors.Is(err, core.ErrProxyNotFound):
+		http.Error(w, "proxy not found", http.StatusNotFound)
+	default:
+		http.Error(w, "failed to update proxies", http.StatusInternalServerError)
+	}
+}
+
+// StatsHandler reports the per-proxy stats of the latest snapshot,
+// along with its version for clients that cache the body.
+func StatsHandler(pool core.Reporter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		stats := pool.Snapshots()
+		snap := pool.Snapshot()
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(stats)
+		setSnapshotVersion(w, snap)
+		_ = json.NewEncoder(w).Encode(struct {
+			Version uint64            `json:"version"`
+			Proxies []core.ProxyStats `json:"proxies"`
+		}{snap.Version, snap.Stats})
 	})
 }
+
+// SubnetStatsHandler reports how the pool's proxies and allocations
+// spread across subnets.
+func SubnetStatsHandler(pool core.Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := pool.Snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		setSnapshotVersion(w, snap)
+		_ = json.NewEncoder(w).Encode(snap.Subnets)
+	}
+}
+
+// ListPoolsHandler lists the configured pools with their proxy counts.
+func ListPoolsHandler(pools map[string]core.Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type poolInfo struct {
+			Name    string `json:"name"`
+			Proxies int    `json:"proxies"`
+			Alive   int    `json:"alive"`
+		}
+
+		resp := make([]poolInfo, 0, len(pools))
+		for name, pool := range pools {
+			snap := pool.Snapshot()
+			resp = append(resp, poolInfo{
+				Name:    name,
+				Proxies: len(snap.Stats),
+				Alive:   len(snap.Alive),
+			})
+		}
+		sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// setSnapshotVersion tells clients which published pool snapshot a
+// response was served from; it only ever increases.
+func setSnapshotVersion(w http.ResponseWriter, snap *core.PoolSnapshot) {
+	w.Header().Set("X-Snapshot-Version", strconv.FormatUint(snap.Version, 10))
+}
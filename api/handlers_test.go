@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nebojsaj1726/proxy-pool/core"
+)
+
+const testProxyURL = "http://10.0.0.1:8080"
+
+func newTestPool(t *testing.T, pc core.ProxyConfig) *core.Pool {
+	t.Helper()
+	pool, err := core.NewPool(core.DefaultPoolName, core.PoolConfig{Proxies: []core.ProxyConfig{pc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestWriteAllocError(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{core.ErrNoMatch, http.StatusUnprocessableEntity, ""},
+		{fmt.Errorf("pool p: %w", core.ErrNoMatch), http.StatusUnprocessableEntity, ""},
+		{&core.RateLimitError{RetryAfter: 1500 * time.Millisecond}, http.StatusServiceUnavailable, "2"},
+		{&core.RateLimitError{RetryAfter: time.Millisecond}, http.StatusServiceUnavailable, "1"},
+		{core.ErrAtCapacity, http.StatusServiceUnavailable, ""},
+		{core.ErrNoAliveProxies, http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeAllocError(w, tt.err)
+		if w.Code != tt.status {
+			t.Errorf("%v: expected %d, got %d", tt.err, tt.status, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%v: expected Retry-After %q, got %q", tt.err, tt.retryAfter, got)
+		}
+	}
+}
+
+func TestAllocateProxyHandler_Errors(t *testing.T) {
+	pool := newTestPool(t, core.ProxyConfig{
+		URL:       testProxyURL,
+		Tags:      map[string]string{"country": "us"},
+		RateLimit: core.RateLimitConfig{Requests: 1, IntervalSeconds: 60},
+	})
+	h := AllocateProxyHandler(pool)
+
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/allocate?max_latency_ms=x", nil)); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid query: expected 400, got %d", w.Code)
+	}
+	if w := serve(h, httptest.NewRequest(http.MethodGet, "/allocate?tag=country:de", nil)); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unmatched tag: expected 422, got %d", w.Code)
+	}
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/allocate", nil))
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["allocated"] != testProxyURL {
+		t.Fatalf("expected %s to be allocated, got %d %v (%v)", testProxyURL, w.Code, resp, err)
+	}
+
+	w = serve(h, httptest.NewRequest(http.MethodGet, "/allocate", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("rate limited: expected 503 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestUpdateProxyHandler(t *testing.T) {
+	pool := newTestPool(t, core.ProxyConfig{URL: testProxyURL})
+	mux := http.NewServeMux()
+	mux.Handle("PATCH /proxies/{id}", UpdateProxyHandler(pool))
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		return serve(mux, httptest.NewRequest(http.MethodPatch, "/proxies/"+id, strings.NewReader(body)))
+	}
+	id := core.ProxyID(testProxyURL)
+
+	tests := []struct {
+		name, id, body string
+		status         int
+	}{
+		{"unknown id", "missing", `{"tier":1}`, http.StatusNotFound},
+		{"malformed body", id, `{`, http.StatusBadRequest},
+		{"url change", id, `{"url":"http://10.0.0.2:8080"}`, http.StatusBadRequest},
+		{"negative tier", id, `{"tier":-1}`, http.StatusBadRequest},
+		{"past expiry", id, `{"expires_at":"2000-01-01"}`, http.StatusBadRequest},
+		{"bad expiry", id, `{"expires_at":"soon"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := patch(tt.id, tt.body); w.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.status, w.Code, w.Body)
+		}
+	}
+
+	w := patch(id, `{"tier":2,"tags":{"country":"us"}}`)
+	var stats core.ProxyStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with stats, got %d (%v)", w.Code, err)
+	}
+	if stats.Tier != 2 || stats.Tags["country"] != "us" {
+		t.Fatalf("expected the update in the stats, got %+v", stats)
+	}
+}
+
+func TestRemoveProxyHandler(t *testing.T) {
+	pool := newTestPool(t, core.ProxyConfig{URL: testProxyURL})
+	mux := http.NewServeMux()
+	mux.Handle("DELETE /proxies/{id}", RemoveProxyHandler(pool))
+	remove := func(target string) *httptest.ResponseRecorder {
+		return serve(mux, httptest.NewRequest(http.MethodDelete, target, nil))
+	}
+	id := core.ProxyID(testProxyURL)
+
+	if w := remove("/proxies/" + id + "?drain_timeout=-1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative drain_timeout: expected 400, got %d", w.Code)
+	}
+	if w := remove("/proxies/" + id + "?drain_timeout=soon"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid drain_timeout: expected 400, got %d", w.Code)
+	}
+	if w := remove("/proxies/missing"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown id: expected 404, got %d", w.Code)
+	}
+
+	w := remove("/proxies/" + id + "?drain_timeout=0")
+	var resp struct {
+		ID      string `json:"id"`
+		Drained bool   `json:"drained"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%v)", w.Code, err)
+	}
+	if resp.ID != id || !resp.Drained {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if len(pool.All()) != 0 {
+		t.Fatal("expected the proxy to be gone")
+	}
+	if w := remove("/proxies/" + id); w.Code != http.StatusNotFound {
+		t.Fatalf("second delete: expected 404, got %d", w.Code)
+	}
+}
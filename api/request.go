@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nebojsaj1726/proxy-pool/core"
+)
+
+// allocationInput carries the selection criteria accepted by /allocate and
+// POST /leases, either as a JSON body or as query parameters. Query
+// parameters win over body fields; repeated ones (tag, prefer_tag,
+// exclude) are appended.
+type allocationInput struct {
+	Domain       string   `json:"domain"`
+	MinScore     *float64 `json:"min_score"`
+	MaxLatencyMS int      `json:"max_latency_ms"`
+	Protocol     string   `json:"protocol"`
+	Exclude      []string `json:"exclude"`
+	Tags         []string `json:"tags"`
+	PreferTags   []string `json:"prefer_tags"`
+	Session      string   `json:"session"`
+	SessionTTL   int      `json:"session_ttl"`
+	Count        int      `json:"count"`
+}
+
+// maxBatchSize caps the count of a batch allocation.
+const maxBatchSize = 100
+
+// decodeBody decodes an optional JSON body into v. An empty body is fine.
+func decodeBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return errors.New("invalid input")
+	}
+	return nil
+}
+
+func (in *allocationInput) applyQuery(r *http.Request) error {
+	q := r.URL.Query()
+
+	if v := q.Get("min_score"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid min_score %q", v)
+		}
+		in.MinScore = &f
+	}
+	if v := q.Get("max_latency_ms"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid max_latency_ms %q", v)
+		}
+		in.MaxLatencyMS = n
+	}
+	if v := q.Get("session_ttl"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid session_ttl %q", v)
+		}
+		in.SessionTTL = n
+	}
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid count %q", v)
+		}
+		in.Count = n
+	}
+	if v := q.Get("domain"); v != "" {
+		in.Domain = v
+	}
+	if v := q.Get("protocol"); v != "" {
+		in.Protocol = v
+	}
+	if v := q.Get("session"); v != "" {
+		in.Session = v
+	}
+
+	in.Exclude = append(in.Exclude, q["exclude"]...)
+	in.Tags = append(in.Tags, q["tag"]...)
+	in.PreferTags = append(in.PreferTags, q["prefer_tag"]...)
+	return nil
+}
+
+func (in *allocationInput) request() (core.AllocationRequest, error) {
+	if in.MaxLatencyMS < 0 {
+		return core.AllocationRequest{}, errors.New("max_latency_ms must not be negative")
+	}
+	if in.SessionTTL < 0 {
+		return core.AllocationRequest{}, errors.New("session_ttl must not be negative")
+	}
+	if in.Count < 0 || in.Count > maxBatchSize {
+		return core.AllocationRequest{}, fmt.Errorf("count must be between 1 and %d", maxBatchSize)
+	}
+	if in.Count > 0 && in.Session != "" {
+		return core.AllocationRequest{}, errors.New("count can't be combined with session")
+	}
+
+	tags, err := parseTags(in.Tags)
+	if err != nil {
+		return core.AllocationRequest{}, err
+	}
+	prefer, err := parseTags(in.PreferTags)
+	if err != nil {
+		return core.AllocationRequest{}, err
+	}
+
+	return core.AllocationRequest{
+		Domain:       in.Domain,
+		MinScore:     in.MinScore,
+		MaxLatencyMS: in.MaxLatencyMS,
+		Protocol:     in.Protocol,
+		Exclude:      in.Exclude,
+		Tags:         tags,
+		PreferTags:   prefer,
+		SessionKey:   in.Session,
+		SessionTTL:   time.Duration(in.SessionTTL) * time.Second,
+	}, nil
+}
+
+func parseTags(raw []string) ([]core.Tag, error) {
+	tags := make([]core.Tag, 0, len(raw))
+	for _, v := range raw {
+		t, err := core.ParseTag(v)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+// proxyInput is the JSON body of POST /proxies and PATCH /proxies/{id}.
+// On PATCH, omitted fields are left unchanged and an empty expires_at
+// clears the expiry.
+type proxyInput struct {
+	URL           string                `json:"url"`
+	MaxConcurrent *int                  `json:"max_concurrent"`
+	Tier          *int                  `json:"tier"`
+	ExpiresAt     *expiryDate           `json:"expires_at"`
+	RateLimit     *core.RateLimitConfig `json:"rate_limit"`
+	Tags          map[string]string     `json:"tags"`
+}
+
+// expiryDate is an expiry in any format core.ParseExpiry accepts; the
+// empty string is the zero time.
+type expiryDate time.Time
+
+func (d *expiryDate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("expires_at must be a string")
+	}
+	if s == "" {
+		*d = expiryDate{}
+		return nil
+	}
+	t, err := core.ParseExpiry(s)
+	if err != nil {
+		return err
+	}
+	*d = expiryDate(t)
+	return nil
+}
+
+func (in proxyInput) config() core.ProxyConfig {
+	pc := core.ProxyConfig{URL: in.URL, Tags: in.Tags}
+	if in.MaxConcurrent != nil {
+		pc.MaxConcurrent = *in.MaxConcurrent
+	}
+	if in.Tier != nil {
+		pc.Tier = *in.Tier
+	}
+	if in.ExpiresAt != nil {
+		pc.ExpiresAt = time.Time(*in.ExpiresAt)
+	}
+	if in.RateLimit != nil {
+		pc.RateLimit = *in.RateLimit
+	}
+	return pc
+}
+
+func (in proxyInput) update() core.ProxyUpdate {
+	u := core.ProxyUpdate{
+		MaxConcurrent: in.MaxConcurrent,
+		Tier:          in.Tier,
+		RateLimit:     in.RateLimit,
+		Tags:          in.Tags,
+	}
+	if in.ExpiresAt != nil {
+		t := time.Time(*in.ExpiresAt)
+		u.ExpiresAt = &t
+	}
+	return u
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -16,11 +17,13 @@ import (
 )
 
 type App struct {
-	DB     *db.Store
+	DB *db.Store
+	// Pool is the default pool, served on the unscoped routes.
 	Pool   core.Pooler
+	Pools  map[string]*core.Pool
 	Mux    *http.ServeMux
 	Server *http.Server
-	Health *health.Manager
+	Health []*health.Manager
 }
 
 func NewApp() (*App, error) {
@@ -28,47 +31,19 @@ func NewApp() (*App, error) {
 
 	database := db.ConnectAndMigrate()
 
-	pool, err := core.LoadConfig("./config.yaml")
+	pools, err := loadPools(database)
 	if err != nil {
 		return nil, err
 	}
 
-	storedProxies, err := database.LoadProxies()
-	if err != nil {
-		log.Printf("warning: failed to load proxies from DB: %v", err)
-	} else {
-		for _, p := range storedProxies {
-			if len(pool.Proxies) > 0 {
-				p.CheckURL = pool.Proxies[0].CheckURL
-				p.Timeout = pool.Proxies[0].Timeout
-			}
-
-			if err := p.RebuildHTTPClient(); err != nil {
-				log.Printf("Skipping invalid DB proxy URL %s: %v", p.URL, err)
-				continue
-			}
-		}
-
-		merged := make([]*core.Proxy, 0)
-		seen := make(map[string]bool)
-
-		for _, p := range storedProxies {
-			merged = append(merged, p)
-			seen[p.URL] = true
-		}
-
-		for _, p := range pool.Proxies {
-			if !seen[p.URL] {
-				merged = append(merged, p)
-			}
-		}
-
-		pool.Proxies = merged
+	managers := make([]*health.Manager, 0, len(pools))
+	for _, name := range poolNames(pools) {
+		pool := pools[name]
+		m := health.New(name, pool, database, pool.CheckInterval, pool.Timeout)
+		m.Start()
+		managers = append(managers, m)
 	}
 
-	healthManager := health.New(pool, database, 5*time.Second)
-	healthManager.Start()
-
 	mux := http.NewServeMux()
 
 	mux.Handle("/auth/register", auth.RegisterHandler(database))
@@ -82,13 +57,13 @@ func NewApp() (*App, error) {
 	})
 
 	protected := http.NewServeMux()
-	protected.Handle("/proxies", api.ListProxiesHandler(pool))
-	protected.Handle("/proxies/stats", api.StatsHandler(pool))
-	protected.Handle("/allocate", api.AllocateProxyHandler(pool))
+	registerPoolRoutes(protected, "", pools[core.DefaultPoolName])
+	for name, pool := range pools {
+		registerPoolRoutes(protected, "/pools/"+name, pool)
+	}
+	protected.Handle("GET /pools", api.ListPoolsHandler(reporters(pools)))
 
-	mux.Handle("/proxies", auth.JWTMiddleware(protected))
-	mux.Handle("/proxies/stats", auth.JWTMiddleware(protected))
-	mux.Handle("/allocate", auth.JWTMiddleware(protected))
+	mux.Handle("/", auth.JWTMiddleware(protected))
 
 	server := &http.Server{
 		Addr: ":8080",
@@ -98,13 +73,52 @@ func NewApp() (*App, error) {
 
 	return &App{
 		DB:     database,
-		Pool:   pool,
+		Pool:   pools[core.DefaultPoolName],
+		Pools:  pools,
 		Mux:    mux,
 		Server: server,
-		Health: healthManager,
+		Health: managers,
 	}, nil
 }
 
+// loadPools builds the pools from config.yaml, restores their proxies and
+// sessions from the database and attaches the database as their store.
+func loadPools(database *db.Store) (map[string]*core.Pool, error) {
+	pools, err := core.LoadPools("./config.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range poolNames(pools) {
+		pool := pools[name]
+
+		// Set before merging so config entries pick up the history of a
+		// retired proxy with the same URL.
+		pool.ProxyStore = database.Proxies(name)
+		storedProxies, err := database.LoadProxies(name)
+		if err != nil {
+			log.Printf("warning: failed to load proxies of pool %s from DB: %v", name, err)
+		} else {
+			pool.MergeStored(storedProxies)
+		}
+
+		// Entries that expired while the server was down, including ones
+		// whose expires_at only comes from the config file.
+		for _, pr := range pool.RetireExpired() {
+			log.Printf("[pool] %s: proxy %s expired at %s, moved out of the pool",
+				name, pr.URL, pr.ExpiresAt.Format(time.RFC3339))
+		}
+		pool.SessionStore = database.Sessions(name)
+		sessions, err := database.LoadSessions(name)
+		if err != nil {
+			log.Printf("warning: failed to load sessions of pool %s from DB: %v", name, err)
+		} else {
+			pool.RestoreSessions(sessions)
+		}
+	}
+	return pools, nil
+}
+
 func (a *App) Start() error {
 	log.Println("Server running on :8080")
 	return a.Server.ListenAndServe()
@@ -115,21 +129,65 @@ func (a *App) Stop() {
 	defer cancel()
 	_ = a.Server.Shutdown(ctx)
 
-	if a.Health != nil {
-		a.Health.Stop()
+	for _, m := range a.Health {
+		m.Stop()
 	}
 
-	if a.Pool != nil {
-		if pool, ok := a.Pool.(*core.Pool); ok {
-			pool.Close()
-			log.Println("[pool] all proxy connections closed")
+	for name, pool := range a.Pools {
+		pool.Close()
+		log.Printf("[pool] %s: all proxy connections closed", name)
 
-			if a.DB != nil {
-				a.DB.SaveAllProxies(pool.Proxies)
-				log.Println("[db] all proxies persisted")
-			}
+		if a.DB != nil {
+			a.DB.SaveAllProxies(name, pool.All())
+			log.Printf("[db] %s: all proxies persisted", name)
 		}
 	}
 
 	log.Println("Server stopped gracefully")
 }
+
+// registerPoolRoutes mounts the pool API under prefix: "" for the default
+// pool's flat routes, "/pools/{name}" for named pools.
+func registerPoolRoutes(mux *http.ServeMux, prefix string, pool core.Pooler) {
+	handle := func(method, path string, h http.Handler) {
+		pattern := prefix + path
+		if method != "" {
+			pattern = method + " " + pattern
+		}
+		mux.Handle(pattern, h)
+	}
+
+	handle("", "/proxies", api.ListProxiesHandler(pool))
+	handle("POST", "/proxies", auth.AdminOnly(api.AddProxyHandler(pool)))
+	handle("POST", "/proxies/import", auth.AdminOnly(api.ImportProxiesHandler(pool)))
+	handle("PATCH", "/proxies/{id}", auth.AdminOnly(api.UpdateProxyHandler(pool)))
+	handle("DELETE", "/proxies/{id}", auth.AdminOnly(api.RemoveProxyHandler(pool)))
+	handle("GET", "/proxies/stats", api.StatsHandler(pool))
+	handle("", "/stats", api.StatsHandler(pool))
+	handle("POST", "/proxies/report", api.ReportHandler(pool))
+	handle("GET", "/proxies/domains", api.DomainScoresHandler(pool))
+	handle("GET", "/proxies/subnets", api.SubnetStatsHandler(pool))
+	handle("", "/allocate", api.AllocateProxyHandler(pool))
+	handle("POST", "/leases", api.AcquireLeaseHandler(pool))
+	handle("DELETE", "/leases/{id}", api.ReleaseLeaseHandler(pool))
+	handle("GET", "/tiers", api.TiersHandler(pool))
+	handle("GET", "/sessions", api.ListSessionsHandler(pool))
+	handle("DELETE", "/sessions/{key}", api.DeleteSessionHandler(pool))
+}
+
+func poolNames(pools map[string]*core.Pool) []string {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func reporters(pools map[string]*core.Pool) map[string]core.Reporter {
+	out := make(map[string]core.Reporter, len(pools))
+	for name, pool := range pools {
+		out[name] = pool
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nebojsaj1726/proxy-pool/auth"
+	"github.com/nebojsaj1726/proxy-pool/core"
+)
+
+func TestRegisterPoolRoutes_AdminOnly(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "alice")
+	pool, err := core.NewPool(core.DefaultPoolName, core.PoolConfig{
+		Proxies: []core.ProxyConfig{{URL: "http://10.0.0.1:8080"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	mux := http.NewServeMux()
+	registerPoolRoutes(mux, "", pool)
+	registerPoolRoutes(mux, "/pools/"+core.DefaultPoolName, pool)
+
+	// The JWT middleware puts the username in the context; the routes
+	// are registered behind it.
+	call := func(username, method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UsernameKey, username))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	id := core.ProxyID("http://10.0.0.1:8080")
+	for _, prefix := range []string{"", "/pools/" + core.DefaultPoolName} {
+		admin := []struct{ method, path, body string }{
+			{http.MethodPost, "/proxies", `{"url":"http://10.0.0.2:8080"}`},
+			{http.MethodPost, "/proxies/import", "10.0.0.3:8080"},
+			{http.MethodPatch, "/proxies/" + id, `{"tier":1}`},
+			{http.MethodDelete, "/proxies/" + id, ""},
+		}
+		for _, r := range admin {
+			if got := call("bob", r.method, prefix+r.path, r.body); got != http.StatusForbidden {
+				t.Errorf("%s %s%s as non-admin: expected 403, got %d", r.method, prefix, r.path, got)
+			}
+		}
+		// Reaching the handler is enough; an unknown ID proves the
+		// request got past AdminOnly.
+		if got := call("alice", http.MethodPatch, prefix+"/proxies/missing", `{"tier":1}`); got != http.StatusNotFound {
+			t.Errorf("PATCH %s/proxies/missing as admin: expected 404, got %d", prefix, got)
+		}
+		if got := call("alice", http.MethodDelete, prefix+"/proxies/missing", ""); got != http.StatusNotFound {
+			t.Errorf("DELETE %s/proxies/missing as admin: expected 404, got %d", prefix, got)
+		}
+		if got := call("bob", http.MethodGet, prefix+"/proxies/stats", ""); got != http.StatusOK {
+			t.Errorf("GET %s/proxies/stats as non-admin: expected 200, got %d", prefix, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/nebojsaj1726/proxy-pool/core"
+	"github.com/nebojsaj1726/proxy-pool/db"
+)
+
+// RunImport implements the import subcommand:
+//
+//	proxy-pool import [-pool name] [-format auto] [-scheme http] [-source label] [-expires date] <file|->
+//
+// The proxies are health-checked and written to the database, where the
+// server picks them up on its next start. To add proxies to a running
+// server use POST /proxies/import instead.
+func RunImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	poolName := fs.String("pool", core.DefaultPoolName, "pool to import into")
+	format := fs.String("format", core.ImportFormatAuto, "list format: auto, lines, csv or json")
+	scheme := fs.String("scheme", "http", "scheme for entries without one")
+	source := fs.String("source", "", "value of the source tag set on imported proxies")
+	expires := fs.String("expires", "", "expiry (YYYY-MM-DD or RFC 3339) of entries without their own")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: proxy-pool import [flags] <file|->")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one file")
+	}
+
+	var expiresAt time.Time
+	if *expires != "" {
+		t, err := core.ParseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		expiresAt = t
+	}
+
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	_ = godotenv.Load()
+	database := db.ConnectAndMigrate()
+	defer database.DB.Close()
+
+	pools, err := loadPools(database)
+	if err != nil {
+		return err
+	}
+	pool, ok := pools[*poolName]
+	if !ok {
+		return fmt.Errorf("unknown pool %q", *poolName)
+	}
+	defer pool.Close()
+
+	res, err := pool.Import(data, core.ImportOptions{
+		Format:        *format,
+		DefaultScheme: *scheme,
+		Source:        *source,
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("added: %d, duplicates: %d, errors: %d\n", len(res.Added), len(res.Duplicates), len(res.Errors))
+	for _, e := range res.Errors {
+		fmt.Printf("line %d: %s: %q\n", e.Line, e.Reason, e.Input)
+	}
+	return nil
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -69,3 +70,34 @@ func TestRegisterAndLogin(t *testing.T) {
 		t.Fatal("expected JWT token in response")
 	}
 }
+
+func TestAdminOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := AdminOnly(ok)
+
+	call := func(username string) int {
+		req := httptest.NewRequest(http.MethodPost, "/proxies", nil)
+		req = req.WithContext(context.WithValue(req.Context(), UsernameKey, username))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	t.Setenv("ADMIN_USERS", "")
+	if got := call("bob"); got != http.StatusForbidden {
+		t.Fatalf("without ADMIN_USERS expected 403, got %d", got)
+	}
+	if got := call(""); got != http.StatusForbidden {
+		t.Fatalf("without a username expected 403, got %d", got)
+	}
+
+	t.Setenv("ADMIN_USERS", "alice, carol")
+	if got := call("alice"); got != http.StatusNoContent {
+		t.Fatalf("admin expected 204, got %d", got)
+	}
+	if got := call("bob"); got != http.StatusForbidden {
+		t.Fatalf("non-admin expected 403, got %d", got)
+	}
+}
@@ -3,6 +3,8 @@ package auth
 import (
 	"context"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,7 +12,10 @@ import (
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey   contextKey = "userID"
+	UsernameKey contextKey = "username"
+)
 
 func JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +36,31 @@ func JWTMiddleware(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), UserIDKey, claims["user_id"])
+		ctx = context.WithValue(ctx, UsernameKey, claims["username"])
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// AdminOnly restricts next to the users listed in the comma-separated
+// ADMIN_USERS environment variable. It must run behind JWTMiddleware.
+// Registration is open, so when ADMIN_USERS is empty nobody is an admin.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, _ := r.Context().Value(UsernameKey).(string)
+		if username == "" || !slices.Contains(adminUsers(), username) {
+			http.Error(w, "admin access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminUsers() []string {
+	var users []string
+	for _, u := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			users = append(users, u)
+		}
+	}
+	return users
+}
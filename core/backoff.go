@@ -0,0 +1,64 @@
+package core
+
+import "time"
+
+// BackoffConfig spaces out health checks of failing proxies. After n
+// consecutive failures the next check is scheduled BaseSeconds*2^(n-1)
+// later, capped at MaxSeconds. A success resets the schedule to the
+// regular check interval.
+type BackoffConfig struct {
+	BaseSeconds int `yaml:"base_seconds"`
+	MaxSeconds  int `yaml:"max_seconds"`
+}
+
+const defaultBackoffMax = 10 * time.Minute
+
+func (c BackoffConfig) baseDelay(interval time.Duration) time.Duration {
+	if c.BaseSeconds > 0 {
+		return time.Duration(c.BaseSeconds) * time.Second
+	}
+	if interval > 0 {
+		return interval
+	}
+	return 5 * time.Second
+}
+
+func (c BackoffConfig) maxDelay() time.Duration {
+	if c.MaxSeconds <= 0 {
+		return defaultBackoffMax
+	}
+	return time.Duration(c.MaxSeconds) * time.Second
+}
+
+// delay returns how long to wait before re-checking a proxy with the
+// given failure streak.
+func (c BackoffConfig) delay(failures int, interval time.Duration) time.Duration {
+	if failures <= 0 {
+		return interval
+	}
+
+	d := c.baseDelay(interval)
+	limit := c.maxDelay()
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// dueForCheck reports whether proxy should be tested in a run that
+// started at now. Half an interval of slack keeps a proxy scheduled for
+// "next tick" from slipping by a whole tick because of timer jitter.
+func (p *Pool) dueForCheck(proxy *Proxy, now time.Time) bool {
+	proxy.mu.Lock()
+	next := proxy.NextCheck
+	proxy.mu.Unlock()
+	return !next.After(now.Add(p.CheckInterval / 2))
+}
+
+// scheduleNextCheck must be called with proxy.mu held.
+func (p *Pool) scheduleNextCheck(proxy *Proxy, now time.Time) {
+	proxy.NextCheck = now.Add(p.Backoff.delay(proxy.ConsecutiveFailures, p.CheckInterval))
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay_DoublesAndCaps(t *testing.T) {
+	cfg := BackoffConfig{BaseSeconds: 5, MaxSeconds: 60}
+	interval := 5 * time.Second
+
+	cases := map[int]time.Duration{
+		0:  5 * time.Second,
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		4:  40 * time.Second,
+		5:  60 * time.Second,
+		50: 60 * time.Second,
+	}
+	for failures, want := range cases {
+		if got := cfg.delay(failures, interval); got != want {
+			t.Errorf("delay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestHealthCheck_SkipsProxiesInBackoff(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{
+			{URL: "http://127.0.0.1:1", Score: 6},
+			{URL: "http://127.0.0.1:2", Score: 6, NextCheck: time.Now().Add(time.Hour)},
+		},
+		CheckInterval: time.Second,
+		Backoff:       BackoffConfig{BaseSeconds: 10, MaxSeconds: 100},
+	}
+
+	// Neither proxy has an http client, so every check fails.
+	pool.HealthCheck(time.Second)
+	pool.HealthCheck(time.Second)
+
+	due, backedOff := pool.Proxies[0], pool.Proxies[1]
+	if due.FailCount != 1 {
+		t.Fatalf("expected second run to skip the backed-off proxy, got %d checks", due.FailCount)
+	}
+	if backedOff.FailCount != 0 {
+		t.Fatalf("expected scheduled proxy to be skipped, got %d checks", backedOff.FailCount)
+	}
+	if wait := time.Until(due.NextCheck); wait < 9*time.Second || wait > 10*time.Second {
+		t.Fatalf("expected next check ~10s out, got %s", wait)
+	}
+
+	due.recordSuccess(10, sourceHealthCheck, 1)
+	due.mu.Lock()
+	pool.scheduleNextCheck(due, time.Now())
+	due.mu.Unlock()
+	if wait := time.Until(due.NextCheck); wait > time.Second {
+		t.Fatalf("expected recovery to reset to the regular interval, got %s", wait)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+const (
+	StrategyThompson = "thompson"
+	StrategyUCB1     = "ucb1"
+)
+
+// StrategyOptions tunes the strategies that take parameters.
+type StrategyOptions struct {
+	// ExplorationRate is the share of bandit (thompson, ucb1) allocations
+	// that go to a uniformly random candidate instead of the one the
+	// model picks, so every proxy keeps being re-evaluated no matter how
+	// bad its record is. Zero means the default of 0.05; negative
+	// disables forced exploration.
+	ExplorationRate float64 `yaml:"exploration_rate"`
+}
+
+const defaultExplorationRate = 0.05
+
+func (o StrategyOptions) explorationRate() float64 {
+	switch {
+	case o.ExplorationRate < 0:
+		return 0
+	case o.ExplorationRate == 0:
+		return defaultExplorationRate
+	case o.ExplorationRate > 1:
+		return 1
+	default:
+		return o.ExplorationRate
+	}
+}
+
+// explore returns a random candidate index with probability rate, or -1.
+func explore(opts StrategyOptions, n int) int {
+	if n > 1 && rand.Float64() < opts.explorationRate() {
+		return rand.IntN(n)
+	}
+	return -1
+}
+
+// banditHorizon is how many of a proxy's latest outcomes the bandit
+// strategies judge it by. Lifetime counters would make a long-running
+// proxy's posterior (Thompson) or confidence bound (UCB1) so narrow, and
+// keep its mean so low after a long outage, that a proxy that recovers
+// would hardly ever get picked again.
+const banditHorizon = 50
+
+// banditCounts returns the successes and failures among the proxy's last
+// banditHorizon outcomes. Until it has any, e.g. right after a restart,
+// it falls back to the lifetime counters, which banditEvidence scales
+// down. Must be called with p.mu held.
+func (p *Proxy) banditCounts() (successes, failures int) {
+	if s, n := p.banditWindow.counts(); n > 0 {
+		return s, n - s
+	}
+	return p.SuccessCount, p.FailCount
+}
+
+// banditEvidence returns the success and failure counts of c scaled down
+// to at most banditHorizon outcomes, keeping their ratio.
+func banditEvidence(c Candidate) (s, f float64) {
+	s, f = float64(c.SuccessCount), float64(c.FailCount)
+	if n := s + f; n > banditHorizon {
+		s, f = s*banditHorizon/n, f*banditHorizon/n
+	}
+	return s, f
+}
+
+// Thompson samples a success rate for every candidate from a Beta
+// posterior over its SuccessCount and FailCount and picks the highest
+// sample. Proxies with little evidence have wide posteriors and are
+// picked now and then even when their mean is lower. Evidence is capped
+// at banditHorizon outcomes, keeping the success ratio.
+type Thompson struct {
+	Options StrategyOptions
+}
+
+func (Thompson) Name() string { return StrategyThompson }
+
+func (t Thompson) Select(candidates []Candidate) int {
+	if i := explore(t.Options, len(candidates)); i >= 0 {
+		return i
+	}
+
+	best, bestSample := 0, -1.0
+	for i, c := range candidates {
+		s, f := banditEvidence(c)
+		sample := sampleBeta(s+1, f+1)
+		if sample > bestSample {
+			best, bestSample = i, sample
+		}
+	}
+	return best
+}
+
+// UCB1 picks the candidate with the highest upper confidence bound on
+// its success rate: mean + sqrt(2 ln N / n), where n is the candidate's
+// number of outcomes and N the total over all candidates. Like Thompson
+// it counts at most banditHorizon outcomes per candidate. Candidates
+// without any outcome are tried first.
+type UCB1 struct {
+	Options StrategyOptions
+}
+
+func (UCB1) Name() string { return StrategyUCB1 }
+
+func (u UCB1) Select(candidates []Candidate) int {
+	if i := explore(u.Options, len(candidates)); i >= 0 {
+		return i
+	}
+
+	total := 0.0
+	for _, cand := range candidates {
+		s, f := banditEvidence(cand)
+		total += s + f
+	}
+	logTotal := math.Log(max(total, 1))
+
+	best, bestIndex := 0, math.Inf(-1)
+	for i, cand := range candidates {
+		s, f := banditEvidence(cand)
+		n := s + f
+		if n == 0 {
+			return i
+		}
+		index := s/n + math.Sqrt(2*logTotal/n)
+		if index > bestIndex || (index == bestIndex && better(cand, candidates[best])) {
+			best, bestIndex = i, index
+		}
+	}
+	return best
+}
+
+// sampleBeta draws from Beta(a, b) as X/(X+Y) with X ~ Gamma(a) and
+// Y ~ Gamma(b).
+func sampleBeta(a, b float64) float64 {
+	x := sampleGamma(a)
+	y := sampleGamma(b)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// sampleGamma draws from Gamma(shape, 1) using Marsaglia and Tsang's
+// method. Shapes below 1 are boosted and corrected.
+func sampleGamma(shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if u < 1-0.0331*x*x*x*x || math.Log(u) < 0.5*x*x+d*(1-v+math.Log(v)) {
+			return d * v
+		}
+	}
+}
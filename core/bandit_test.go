@@ -0,0 +1,117 @@
+package core
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// simulate runs rounds of allocation against arms with the given success
+// probabilities, feeding every outcome back into the arm's proxy the way
+// scan does, and returns how often each arm was picked in the last
+// window rounds.
+func simulate(s AllocationStrategy, arms []Candidate, probs func(round, arm int) float64, rounds, window int) []int {
+	picks := make([]int, len(arms))
+	for r := range rounds {
+		i := s.Select(arms)
+		arms[i].Proxy.recordOutcome(rand.Float64() < probs(r, i))
+		arms[i].SuccessCount, arms[i].FailCount = arms[i].Proxy.banditCounts()
+		if r >= rounds-window {
+			picks[i]++
+		}
+	}
+	return picks
+}
+
+func newArms(n int) []Candidate {
+	arms := make([]Candidate, n)
+	for i := range arms {
+		arms[i] = Candidate{Proxy: &Proxy{URL: string(rune('A' + i))}}
+	}
+	return arms
+}
+
+func banditStrategies() []AllocationStrategy {
+	return []AllocationStrategy{Thompson{}, UCB1{}}
+}
+
+func TestBandit_ConvergesButKeepsExploring(t *testing.T) {
+	probs := []float64{0.5, 0.6, 0.95, 0.7, 0.3}
+
+	for _, s := range banditStrategies() {
+		arms := newArms(len(probs))
+		picks := simulate(s, arms, func(_, arm int) float64 { return probs[arm] }, 5000, 1000)
+
+		if picks[2] < 700 {
+			t.Errorf("%s: expected the best proxy to get most of the late traffic, got %v", s.Name(), picks)
+		}
+		for i, a := range arms {
+			if a.SuccessCount+a.FailCount < 5 {
+				t.Errorf("%s: proxy %d was never re-evaluated (%d trials)", s.Name(), i, a.SuccessCount+a.FailCount)
+			}
+		}
+	}
+}
+
+func TestBandit_RecoveredProxyWinsTrafficBack(t *testing.T) {
+	// Proxy 0 is broken for the first rounds and then becomes the best
+	// one; best-score style allocation would never find out. How long
+	// it was broken must not matter: only its latest outcomes count.
+	for _, outage := range []int{2000, 20000} {
+		probs := func(round, arm int) float64 {
+			switch {
+			case arm == 0 && round < outage:
+				return 0.05
+			case arm == 0:
+				return 0.98
+			default:
+				return 0.7
+			}
+		}
+
+		for _, s := range banditStrategies() {
+			arms := newArms(3)
+			picks := simulate(s, arms, probs, outage+4000, 1000)
+			if picks[0] < 500 {
+				t.Errorf("%s: expected the proxy recovered after %d rounds to win back traffic, got %v", s.Name(), outage, picks)
+			}
+		}
+	}
+}
+
+func TestBandit_ExplorationRate(t *testing.T) {
+	probs := []float64{0.95, 0.5, 0.5, 0.5}
+	explored := func(s AllocationStrategy) int {
+		arms := newArms(len(probs))
+		picks := simulate(s, arms, func(_, arm int) float64 { return probs[arm] }, 4000, 2000)
+		return 2000 - picks[0]
+	}
+
+	for _, name := range []string{StrategyThompson, StrategyUCB1} {
+		low, _ := NewStrategyWithOptions(name, StrategyOptions{ExplorationRate: -1})
+		high, _ := NewStrategyWithOptions(name, StrategyOptions{ExplorationRate: 0.4})
+		if l, h := explored(low), explored(high); l >= h || h < 400 {
+			t.Errorf("%s: expected more exploration at a higher rate, got %d vs %d", name, l, h)
+		}
+	}
+}
+
+func TestUCB1_TriesUntestedFirst(t *testing.T) {
+	arms := []Candidate{
+		{SuccessCount: 100},
+		{},
+	}
+	if got := (UCB1{Options: StrategyOptions{ExplorationRate: -1}}).Select(arms); got != 1 {
+		t.Fatalf("expected the untested candidate, got %d", got)
+	}
+}
+
+func TestSampleBeta_Mean(t *testing.T) {
+	const n = 20000
+	sum := 0.0
+	for range n {
+		sum += sampleBeta(3, 7)
+	}
+	if mean := sum / n; mean < 0.28 || mean > 0.32 {
+		t.Fatalf("expected mean near 0.3, got %.3f", mean)
+	}
+}
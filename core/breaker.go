@@ -0,0 +1,147 @@
+package core
+
+import (
+	"log"
+	"time"
+)
+
+// BreakerState is the circuit breaker state of a proxy.
+//
+//   - closed: healthy, allocatable
+//   - open: failing, never allocated until the cool-down passes
+//   - half-open: cool-down passed, a limited number of trial
+//     allocations decide whether it closes again or re-opens
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ParseBreakerState is the inverse of BreakerState.String. Unknown values
+// parse as closed.
+func ParseBreakerState(s string) BreakerState {
+	switch s {
+	case "open":
+		return BreakerOpen
+	case "half-open":
+		return BreakerHalfOpen
+	default:
+		return BreakerClosed
+	}
+}
+
+// BreakerConfig tunes the per-proxy circuit breaker. Zero fields fall
+// back to the defaults below.
+type BreakerConfig struct {
+	FailureThreshold int `yaml:"failure_threshold"`
+	CooldownSeconds  int `yaml:"cooldown_seconds"`
+	HalfOpenMax      int `yaml:"half_open_max"`
+}
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 30 * time.Second
+	defaultHalfOpenMax      = 1
+)
+
+func (c BreakerConfig) failureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return defaultFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+func (c BreakerConfig) cooldown() time.Duration {
+	if c.CooldownSeconds <= 0 {
+		return defaultCooldown
+	}
+	return time.Duration(c.CooldownSeconds) * time.Second
+}
+
+func (c BreakerConfig) halfOpenMax() int {
+	if c.HalfOpenMax <= 0 {
+		return defaultHalfOpenMax
+	}
+	return c.HalfOpenMax
+}
+
+// The helpers below must be called with p.mu held.
+
+// refreshState moves an open breaker to half-open once its cool-down
+// has passed.
+func (p *Proxy) refreshState(now time.Time) {
+	if p.State == BreakerOpen && now.Sub(p.StateChangedAt) >= p.Breaker.cooldown() {
+		p.transition(BreakerHalfOpen, now)
+	}
+}
+
+func (p *Proxy) transition(to BreakerState, now time.Time) {
+	if p.State == to {
+		return
+	}
+	log.Printf("Proxy breaker %s: %s -> %s (score=%.1f)", p.URL, p.State, to, p.Score)
+	p.State = to
+	p.StateChangedAt = now
+	p.halfOpenTrials = 0
+}
+
+// alive reports whether the breaker lets any traffic through.
+func (p *Proxy) alive(now time.Time) bool {
+	p.refreshState(now)
+	return p.State != BreakerOpen
+}
+
+// admits reports whether the breaker allows one more allocation.
+// In half-open only HalfOpenMax trials are let through.
+func (p *Proxy) admits(now time.Time) bool {
+	p.refreshState(now)
+	switch p.State {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return p.halfOpenTrials < p.Breaker.halfOpenMax()
+	default:
+		return false
+	}
+}
+
+// admit records an allocation that went through the breaker.
+func (p *Proxy) admit() {
+	if p.State == BreakerHalfOpen {
+		p.halfOpenTrials++
+	}
+}
+
+func (p *Proxy) breakerSuccess(now time.Time) {
+	p.ConsecutiveFailures = 0
+	p.refreshState(now)
+	if p.State == BreakerHalfOpen {
+		p.transition(BreakerClosed, now)
+	}
+}
+
+func (p *Proxy) breakerFailure(now time.Time) {
+	p.ConsecutiveFailures++
+	p.refreshState(now)
+	switch p.State {
+	case BreakerClosed:
+		if p.ConsecutiveFailures >= p.Breaker.failureThreshold() {
+			p.transition(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		p.transition(BreakerOpen, now)
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	p := &Proxy{URL: "A", Score: 6, Breaker: BreakerConfig{FailureThreshold: 3}}
+
+	for i := range 2 {
+		p.recordFailure("boom", nil, sourceHealthCheck, 1)
+		if p.State != BreakerClosed {
+			t.Fatalf("breaker opened after %d failures", i+1)
+		}
+	}
+
+	p.recordFailure("boom", nil, sourceHealthCheck, 1)
+	if p.State != BreakerOpen {
+		t.Fatalf("expected open after 3 failures, got %s", p.State)
+	}
+	if p.StateChangedAt.IsZero() {
+		t.Fatal("expected transition time to be recorded")
+	}
+}
+
+func TestBreaker_SuccessResetsFailureStreak(t *testing.T) {
+	p := &Proxy{URL: "A", Score: 6, Breaker: BreakerConfig{FailureThreshold: 2}}
+
+	p.recordFailure("boom", nil, sourceHealthCheck, 1)
+	p.recordSuccess(100, sourceHealthCheck, 1)
+	p.recordFailure("boom", nil, sourceHealthCheck, 1)
+
+	if p.State != BreakerClosed {
+		t.Fatalf("expected interleaved failures to keep breaker closed, got %s", p.State)
+	}
+}
+
+func TestBreaker_HalfOpenTrials(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{{
+			URL:            "A",
+			Score:          6,
+			State:          BreakerOpen,
+			StateChangedAt: time.Now().Add(-time.Minute),
+			Breaker:        BreakerConfig{CooldownSeconds: 10, HalfOpenMax: 2},
+		}},
+	}
+	proxy := pool.Proxies[0]
+
+	for range 2 {
+		if _, err := pool.Allocate(); err != nil {
+			t.Fatalf("expected half-open trial, got %v", err)
+		}
+	}
+	if proxy.State != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", proxy.State)
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Fatal("expected trial budget to be exhausted")
+	}
+
+	proxy.recordFailure("still broken", nil, sourceClient, 1)
+	if proxy.State != BreakerOpen {
+		t.Fatalf("expected failed trial to re-open, got %s", proxy.State)
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Fatal("expected freshly re-opened breaker to reject allocation")
+	}
+
+	proxy.StateChangedAt = time.Now().Add(-time.Minute)
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	proxy.recordSuccess(50, sourceClient, 1)
+	if proxy.State != BreakerClosed {
+		t.Fatalf("expected successful trial to close, got %s", proxy.State)
+	}
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPoolName is the pool built from the top-level config section and
+// served on the unscoped API routes.
+const DefaultPoolName = "default"
+
+const defaultCheckInterval = 5 * time.Second
+
+var poolNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Config is the layout of config.yaml. The top-level fields describe the
+// default pool; Pools adds further named pools, each with its own
+// proxies and check settings. Named pools don't inherit anything from the
+// top-level section.
+type Config struct {
+	PoolConfig `yaml:",inline"`
+	Pools      map[string]PoolConfig `yaml:"pools"`
+}
+
+// PoolConfig holds everything that can be set per pool.
+type PoolConfig struct {
+	HealthCheckURL  string             `yaml:"health_check_url"`
+	TimeoutSeconds  int                `yaml:"timeout_seconds"`
+	IntervalSeconds int                `yaml:"interval_seconds"`
+	Strategy        string             `yaml:"strategy"`
+	StrategyOptions StrategyOptions    `yaml:"strategy_options"`
+	LeaseTTL        int                `yaml:"lease_ttl_seconds"`
+	MaxConcurrent   int                `yaml:"max_concurrent"`
+	RateLimit       RateLimitConfig    `yaml:"rate_limit"`
+	Breaker         BreakerConfig      `yaml:"breaker"`
+	LatencyScore    LatencyScoreConfig `yaml:"latency_score"`
+	Scoring         ScoringConfig      `yaml:"scoring"`
+	Backoff         BackoffConfig      `yaml:"recheck_backoff"`
+	Feedback        FeedbackConfig     `yaml:"feedback"`
+	Probation       ProbationConfig    `yaml:"probation"`
+	Tiers           TierConfig         `yaml:"tiers"`
+	Diversity       DiversityConfig    `yaml:"diversity"`
+	// ExpiryWarningHours is how long before their expires_at proxies are
+	// reported as expiring. Zero means 72.
+	ExpiryWarningHours int           `yaml:"expiry_warning_hours"`
+	Proxies            []ProxyConfig `yaml:"proxies"`
+}
+
+// ProxyConfig is a single entry of the proxies list. It can be written
+// either as a plain URL string or as a mapping with per-proxy settings.
+type ProxyConfig struct {
+	URL           string            `yaml:"url"`
+	MaxConcurrent int               `yaml:"max_concurrent"`
+	Tier          int               `yaml:"tier"`
+	ExpiresAt     time.Time         `yaml:"expires_at"`
+	RateLimit     RateLimitConfig   `yaml:"rate_limit"`
+	Tags          map[string]string `yaml:"tags"`
+}
+
+func (c *ProxyConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.URL)
+	}
+	type plain ProxyConfig
+	return node.Decode((*plain)(c))
+}
+
+// LoadConfig reads path and returns the default pool.
+func LoadConfig(path string) (*Pool, error) {
+	pools, err := LoadPools(path)
+	if err != nil {
+		return nil, err
+	}
+	return pools[DefaultPoolName], nil
+}
+
+// LoadPools reads path and builds every pool it describes, keyed by name.
+// The result always contains DefaultPoolName, possibly with no proxies.
+func LoadPools(path string) (map[string]*Pool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	configs := map[string]PoolConfig{}
+	for name, pc := range cfg.Pools {
+		if !poolNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid pool name %q: use letters, digits, '-' and '_'", name)
+		}
+		configs[name] = pc
+	}
+	if _, ok := configs[DefaultPoolName]; ok {
+		if len(cfg.PoolConfig.Proxies) > 0 {
+			return nil, fmt.Errorf("pool %q is defined both at the top level and under pools", DefaultPoolName)
+		}
+	} else {
+		configs[DefaultPoolName] = cfg.PoolConfig
+	}
+
+	pools := make(map[string]*Pool, len(configs))
+	for name, pc := range configs {
+		pool, err := NewPool(name, pc)
+		if err != nil {
+			return nil, fmt.Errorf("pool %q: %w", name, err)
+		}
+		pools[name] = pool
+	}
+	return pools, nil
+}
+
+// NewPool builds a pool from its config section.
+func NewPool(name string, cfg PoolConfig) (*Pool, error) {
+	strategy, err := NewStrategyWithOptions(cfg.Strategy, cfg.StrategyOptions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cfg.Scoring.NewScorer(0); err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	pool := &Pool{
+		Name:          name,
+		CheckURL:      cfg.HealthCheckURL,
+		Timeout:       time.Duration(cfg.TimeoutSeconds) * time.Second,
+		CheckInterval: interval,
+		Strategy:      strategy,
+		LeaseTTL:      time.Duration(cfg.LeaseTTL) * time.Second,
+		Feedback:      cfg.Feedback,
+		MaxConcurrent: cfg.MaxConcurrent,
+		RateLimit:     cfg.RateLimit,
+		Breaker:       cfg.Breaker,
+		LatencyScore:  cfg.LatencyScore,
+		Scoring:       cfg.Scoring,
+		Backoff:       cfg.Backoff,
+		Probation:     cfg.Probation,
+		Tiering:       cfg.Tiers,
+		Diversity:     cfg.Diversity,
+		ExpiryWarning: time.Duration(cfg.ExpiryWarningHours) * time.Hour,
+	}
+
+	proxies := make([]*Proxy, 0, len(cfg.Proxies))
+	for i, pc := range cfg.Proxies {
+		if !strings.Contains(pc.URL, "://") {
+			u, err := normalizeProxyURL(pc.URL, "http")
+			if err != nil {
+				log.Printf("Skipping proxy entry %d of pool %s (%q): %v", i+1, name, pc.URL, err)
+				continue
+			}
+			pc.URL = u
+		}
+		if pc.Tier < 0 {
+			log.Printf("Skipping proxy entry %d of pool %s (%q): tier must not be negative", i+1, name, pc.URL)
+			continue
+		}
+		proxy, err := pool.newProxy(pc)
+		if err != nil {
+			log.Printf("Skipping proxy entry %d of pool %s (%q): %v", i+1, name, pc.URL, err)
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	pool.Proxies = proxies
+
+	return pool, nil
+}
+
+func (p *Pool) newProxy(pc ProxyConfig) (*Proxy, error) {
+	proxyURL, err := url.Parse(pc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyURL(proxyURL),
+	}
+
+	return &Proxy{
+		URL:            pc.URL,
+		State:          BreakerClosed,
+		StateChangedAt: time.Now(),
+		LastTest:       time.Now(),
+		CheckURL:       p.CheckURL,
+		Timeout:        p.Timeout,
+		UsageCount:     0,
+		FailCount:      0,
+		SuccessCount:   0,
+		Score:          initialScore,
+		MaxConcurrent:  pc.MaxConcurrent,
+		Tier:           pc.Tier,
+		ExpiresAt:      pc.ExpiresAt,
+		RateLimit:      pc.RateLimit,
+		Tags:           pc.Tags,
+		Breaker:        p.Breaker,
+		LatencyScore:   p.LatencyScore,
+		Scoring:        p.Scoring,
+		OnProbation:    p.Probation.enabled(),
+		Probation:      p.Probation,
+		transport:      transport,
+		client: &http.Client{
+			Timeout:   p.Timeout,
+			Transport: transport,
+		},
+	}, nil
+}
+
+// MergeStored combines proxies loaded from storage with the ones built
+// from the config file. Stored proxies keep their runtime state (score,
+// counters, breaker); settings that come from configuration (check URL,
+// timeout, breaker tuning and any per-proxy options) are taken from the
+// pool. Proxies only present in the config are appended, picking up the
+// history of a retired proxy with the same URL when the ProxyStore is
+// already set.
+func (p *Pool) MergeStored(stored []*Proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	configured := make(map[string]*Proxy, len(p.Proxies))
+	for _, c := range p.Proxies {
+		configured[c.URL] = c
+	}
+
+	merged := make([]*Proxy, 0, len(stored)+len(p.Proxies))
+	seen := make(map[string]bool, len(stored))
+
+	for _, s := range stored {
+		if c, ok := configured[s.URL]; ok {
+			s.MaxConcurrent = c.MaxConcurrent
+			s.Tier = c.Tier
+			s.ExpiresAt = c.ExpiresAt
+			s.RateLimit = c.RateLimit
+			s.Tags = c.Tags
+		}
+		s.CheckURL = p.CheckURL
+		s.Timeout = p.Timeout
+		s.Breaker = p.Breaker
+		s.LatencyScore = p.LatencyScore
+		s.Scoring = p.Scoring
+		s.Probation = p.Probation
+		if !p.Probation.enabled() {
+			s.OnProbation = false
+		}
+
+		if err := s.RebuildHTTPClient(); err != nil {
+			log.Printf("Skipping invalid DB proxy URL %s: %v", s.URL, err)
+			continue
+		}
+
+		merged = append(merged, s)
+		seen[s.URL] = true
+	}
+
+	for _, c := range p.Proxies {
+		if !seen[c.URL] {
+			p.revive(c)
+			merged = append(merged, c)
+		}
+	}
+
+	p.setProxies(merged)
+}
@@ -0,0 +1,201 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, cfg string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPools_NamedPools(t *testing.T) {
+	path := writeConfig(t, `
+health_check_url: "https://example.com"
+timeout_seconds: 2
+proxies:
+  - "http://10.0.0.1:8080"
+pools:
+  scraping:
+    health_check_url: "https://target.example"
+    timeout_seconds: 7
+    interval_seconds: 30
+    strategy: round-robin
+    proxies:
+      - "http://10.0.1.1:8080"
+      - "http://10.0.1.2:8080"
+`)
+
+	pools, err := LoadPools(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 2 {
+		t.Fatalf("expected default and scraping pools, got %d", len(pools))
+	}
+
+	def := pools[DefaultPoolName]
+	if def.Name != DefaultPoolName || len(def.Proxies) != 1 || def.CheckInterval != defaultCheckInterval {
+		t.Fatalf("unexpected default pool: %+v", def)
+	}
+
+	sc := pools["scraping"]
+	if sc.Name != "scraping" || len(sc.Proxies) != 2 {
+		t.Fatalf("unexpected scraping pool: %+v", sc)
+	}
+	if sc.CheckInterval != 30*time.Second || sc.Timeout != 7*time.Second {
+		t.Fatalf("expected per-pool interval and timeout, got %s / %s", sc.CheckInterval, sc.Timeout)
+	}
+	if _, ok := sc.Strategy.(*RoundRobin); !ok {
+		t.Fatalf("expected round-robin strategy, got %T", sc.Strategy)
+	}
+	for _, p := range sc.Proxies {
+		if p.CheckURL != "https://target.example" || p.Timeout != 7*time.Second {
+			t.Fatalf("proxy %s did not get the pool's check settings: %s %s", p.URL, p.CheckURL, p.Timeout)
+		}
+	}
+}
+
+func TestLoadPools_RejectsDuplicateDefault(t *testing.T) {
+	path := writeConfig(t, `
+proxies:
+  - "http://10.0.0.1:8080"
+pools:
+  default:
+    proxies:
+      - "http://10.0.0.2:8080"
+`)
+	if _, err := LoadPools(path); err == nil {
+		t.Fatal("expected an error for a default pool defined twice")
+	}
+}
+
+func TestLoadPools_RejectsBadPoolName(t *testing.T) {
+	path := writeConfig(t, `
+pools:
+  "a/b":
+    proxies: []
+`)
+	if _, err := LoadPools(path); err == nil {
+		t.Fatal("expected an error for an invalid pool name")
+	}
+}
+
+func TestMergeStored_UsesPoolCheckSettings(t *testing.T) {
+	pool, err := NewPool("eu", PoolConfig{
+		HealthCheckURL: "https://eu.example",
+		TimeoutSeconds: 4,
+		Proxies: []ProxyConfig{
+			{URL: "http://10.0.0.1:8080", Tags: map[string]string{"country": "de"}},
+			{URL: "http://10.0.0.2:8080"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &Proxy{URL: "http://10.0.0.1:8080", Score: 9, UsageCount: 12}
+	pool.MergeStored([]*Proxy{stored})
+
+	if len(pool.Proxies) != 2 || pool.Proxies[0] != stored {
+		t.Fatalf("expected stored proxy first, then the config-only one: %+v", pool.Proxies)
+	}
+	if stored.CheckURL != "https://eu.example" || stored.Timeout != 4*time.Second {
+		t.Fatalf("stored proxy kept stale check settings: %s %s", stored.CheckURL, stored.Timeout)
+	}
+	if stored.Score != 9 || stored.Tags["country"] != "de" {
+		t.Fatalf("expected stored state and config tags, got score=%.1f tags=%v", stored.Score, stored.Tags)
+	}
+}
+
+func TestLoadConfig_ProviderShorthandEntries(t *testing.T) {
+	path := writeConfig(t, `
+proxies:
+  - "10.0.0.1:8080"
+  - "10.0.0.2:8080:alice:pw"
+  - "not-a-proxy"
+  - "http://10.0.0.3:8080"
+`)
+
+	pool, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range pool.Proxies {
+		got = append(got, p.URL)
+	}
+	want := []string{"http://10.0.0.1:8080", "http://alice:pw@10.0.0.2:8080", "http://10.0.0.3:8080"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLoadPools_ExpiresAt(t *testing.T) {
+	path := writeConfig(t, `
+health_check_url: "https://example.com"
+expiry_warning_hours: 24
+proxies:
+  - url: "http://10.0.0.1:8080"
+    expires_at: 2999-01-31
+  - url: "http://10.0.0.2:8080"
+    expires_at: 2001-01-31T12:00:00Z
+  - "http://10.0.0.3:8080"
+`)
+
+	pools, err := LoadPools(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := pools[DefaultPoolName]
+	if def.ExpiryWarning != 24*time.Hour {
+		t.Fatalf("expected a 24h expiry warning, got %s", def.ExpiryWarning)
+	}
+	if len(def.Proxies) != 3 {
+		t.Fatalf("expected every entry to be kept until it is retired, got %d proxies", len(def.Proxies))
+	}
+	if got := def.Proxies[0].ExpiresAt.Format(time.DateOnly); got != "2999-01-31" {
+		t.Fatalf("expected expires_at to be read, got %s", got)
+	}
+	if !def.Proxies[2].ExpiresAt.IsZero() {
+		t.Fatalf("expected no expiry on the shorthand entry, got %v", def.Proxies[2].ExpiresAt)
+	}
+}
+
+func TestMergeStored_AppliesConfigExpiry(t *testing.T) {
+	pool, err := NewPool(DefaultPoolName, PoolConfig{
+		Proxies: []ProxyConfig{{URL: "http://10.0.0.1:8080", ExpiresAt: time.Now().Add(-time.Hour)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memProxyStore{}
+	pool.ProxyStore = store
+
+	// Saved before the entry had an expiry.
+	stored := &Proxy{URL: "http://10.0.0.1:8080", Score: 10, LastTest: time.Now()}
+	pool.MergeStored([]*Proxy{stored})
+
+	if _, err := pool.Allocate(); !errors.Is(err, ErrNoAliveProxies) {
+		t.Fatalf("expected the expired proxy not to be allocated, got %v", err)
+	}
+	if retired := pool.RetireExpired(); len(retired) != 1 || retired[0] != stored {
+		t.Fatalf("expected the stored proxy to be retired with the config expiry, got %v", retired)
+	}
+	if len(store.retired) != 1 {
+		t.Fatalf("expected the store to mark it expired, got %v", store.retired)
+	}
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+// initialScore is the score new proxies start with, and the default
+// score aging moves toward.
+const initialScore = 6.0
+
+// ageSweepSteps is how many times per half-life allocation re-ages every
+// proxy so the score index doesn't rank on stale scores.
+const ageSweepSteps = 64
+
+// Ager is implemented by scoring models whose evidence can fade with
+// time, see ScoringConfig.HalfLifeSeconds. Age keeps the fraction keep
+// (0..1) of the evidence behind current, lets the rest revert to
+// neutral, and returns the new score.
+type Ager interface {
+	Age(current, keep, neutral float64) float64
+}
+
+func (c ScoringConfig) halfLife() time.Duration {
+	if c.HalfLifeSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.HalfLifeSeconds * float64(time.Second))
+}
+
+func (c ScoringConfig) neutral() float64 {
+	if c.Neutral == nil {
+		return initialScore
+	}
+	return clampScore(*c.Neutral)
+}
+
+// keep returns the share of evidence that survives elapsed.
+func (c ScoringConfig) keep(elapsed time.Duration) float64 {
+	return math.Exp2(-elapsed.Seconds() / c.HalfLifeSeconds)
+}
+
+func (a Additive) Age(current, keep, neutral float64) float64 {
+	return clampScore(neutral + (current-neutral)*keep)
+}
+
+// Age fades the evidence toward a prior of PriorStrength outcomes at the
+// neutral rate.
+func (b *Beta) Age(_, keep, neutral float64) float64 {
+	n := orDefault(b.Config.PriorStrength, defaultBetaPriorStrength)
+	rate := rateFromScore(neutral)
+	b.Alpha = b.Alpha*keep + (1-keep)*n*rate
+	b.Beta = b.Beta*keep + (1-keep)*n*(1-rate)
+	return b.score()
+}
+
+// Age moves the faded part of each slot's weight over to the neutral
+// rate. It leaves the slot when the window moves on.
+func (w *Window) Age(_, keep, neutral float64) float64 {
+	w.neutral = rateFromScore(neutral)
+	for i := range w.slots {
+		s := &w.slots[i]
+		faded := s.weight * (1 - keep)
+		s.weight -= faded
+		s.faded += faded
+	}
+	return w.score()
+}
+
+// age applies time-based score aging to the proxy's global and domain
+// scores, see ScoringConfig.HalfLifeSeconds. Scores are aged lazily, when
+// they are read, allocated on or updated. Must be called with p.mu held.
+func (p *Proxy) age(now time.Time) {
+	if p.Scoring.halfLife() <= 0 {
+		return
+	}
+
+	if p.ScoredAt.IsZero() {
+		p.ScoredAt = now
+	}
+	if elapsed := now.Sub(p.ScoredAt); elapsed > 0 {
+		score := p.ageWith(p.scorer(), p.Score, elapsed)
+		p.ScoredAt = now
+		if score != p.Score {
+			p.Score = score
+			p.scoreChanged()
+		}
+	}
+
+	for domain, ds := range p.DomainScores {
+		if ds.AgedAt.IsZero() {
+			ds.AgedAt = ds.UpdatedAt
+		}
+		elapsed := now.Sub(ds.AgedAt)
+		if elapsed <= 0 {
+			continue
+		}
+		ds.Score = p.ageWith(p.domainScorer(domain, ds.Score), ds.Score, elapsed)
+		ds.AgedAt = now
+		p.DomainScores[domain] = ds
+	}
+}
+
+func (p *Proxy) ageWith(s Scorer, current float64, elapsed time.Duration) float64 {
+	ager, ok := s.(Ager)
+	if !ok {
+		return current
+	}
+	return ager.Age(current, p.Scoring.keep(elapsed), p.Scoring.neutral())
+}
+
+// ageAll ages every proxy when a sweep is due, so the score index picks
+// up the new scores. Must be called with p.mu held.
+func (p *Pool) ageAll(now time.Time) {
+	halfLife := p.Scoring.halfLife()
+	if halfLife <= 0 || now.Sub(p.agedAt) < halfLife/ageSweepSteps {
+		return
+	}
+	p.agedAt = now
+	for _, proxy := range p.Proxies {
+		proxy.mu.Lock()
+		proxy.age(now)
+		proxy.mu.Unlock()
+	}
+}
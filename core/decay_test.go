@@ -0,0 +1,150 @@
+package core
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const halfLifeHour = 3600
+
+func TestAge_HalfLifeTowardNeutral(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8888")
+	p.Scoring = ScoringConfig{HalfLifeSeconds: halfLifeHour}
+	now := time.Now()
+	p.Score = -5
+	p.ScoredAt = now.Add(-time.Hour)
+
+	p.age(now)
+	if want := initialScore + (-5-initialScore)/2; math.Abs(p.Score-want) > 1e-9 {
+		t.Fatalf("expected %.2f after one half-life, got %.2f", want, p.Score)
+	}
+	if !p.ScoredAt.Equal(now) {
+		t.Fatalf("expected ScoredAt to move to now, got %v", p.ScoredAt)
+	}
+
+	// Aging is lazy and path-independent: two half-hour steps equal one
+	// hour.
+	q := newTestProxy("http://127.0.0.1:8889")
+	q.Scoring = p.Scoring
+	q.Score = -5
+	q.ScoredAt = now.Add(-time.Hour)
+	q.age(now.Add(-30 * time.Minute))
+	q.age(now)
+	if math.Abs(q.Score-p.Score) > 1e-9 {
+		t.Fatalf("expected stepwise aging to match, got %.4f and %.4f", q.Score, p.Score)
+	}
+}
+
+func TestAge_DisabledWithoutHalfLife(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8888")
+	p.Score = -5
+	p.ScoredAt = time.Now().Add(-24 * time.Hour)
+
+	p.age(time.Now())
+	if p.Score != -5 {
+		t.Fatalf("expected no aging without a half-life, got %.2f", p.Score)
+	}
+}
+
+func TestAge_CustomNeutral(t *testing.T) {
+	neutral := 0.0
+	p := newTestProxy("http://127.0.0.1:8888")
+	p.Scoring = ScoringConfig{HalfLifeSeconds: halfLifeHour, Neutral: &neutral}
+	now := time.Now()
+	p.Score = 10
+	p.ScoredAt = now.Add(-10 * time.Hour)
+
+	p.age(now)
+	if p.Score > 0.01 {
+		t.Fatalf("expected the score to approach 0 after ten half-lives, got %.3f", p.Score)
+	}
+}
+
+func TestAge_StatefulModelsRevertToNeutral(t *testing.T) {
+	for _, model := range []string{ScoringBeta, ScoringWindow} {
+		t.Run(model, func(t *testing.T) {
+			cfg := ScoringConfig{Model: model, HalfLifeSeconds: halfLifeHour, Window: WindowConfig{Size: 10}}
+			s := cfg.newScorer(initialScore)
+			score := 0.0
+			for range 20 {
+				score = s.Observe(score, ScoreOutcome{Weight: 1})
+			}
+			if score > 0 {
+				t.Fatalf("expected a low score after failures, got %.2f", score)
+			}
+
+			half := s.(Ager).Age(score, 0.5, initialScore)
+			if half <= score || half >= initialScore {
+				t.Fatalf("expected one half-life to land between %.2f and %.2f, got %.2f", score, initialScore, half)
+			}
+			if got := s.(Ager).Age(half, 0, initialScore); math.Abs(got-initialScore) > 1e-9 {
+				t.Fatalf("expected full aging to reach neutral, got %.4f", got)
+			}
+		})
+	}
+}
+
+func TestNewScorer_HalfLifeTurnsOffPerOutcomeDecay(t *testing.T) {
+	cfg := ScoringConfig{HalfLifeSeconds: halfLifeHour}
+	s := cfg.newScorer(initialScore)
+	if got := s.Observe(5, ScoreOutcome{Success: true, Weight: 1}); math.Abs(got-(5+defaultSuccessGain)) > 1e-9 {
+		t.Fatalf("expected a plain gain without decay, got %.4f", got)
+	}
+}
+
+func TestAllocate_AgesIdleScores(t *testing.T) {
+	for name, strategy := range map[string]AllocationStrategy{
+		"indexed": nil,
+		"scan":    LeastLatency{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			weekOld := newTestProxy("http://127.0.0.1:8888")
+			recent := newTestProxy("http://127.0.0.1:8889")
+			for _, p := range []*Proxy{weekOld, recent} {
+				p.Scoring = ScoringConfig{HalfLifeSeconds: halfLifeHour}
+				p.Score = -5
+			}
+			weekOld.ScoredAt = now.Add(-7 * 24 * time.Hour)
+			recent.ScoredAt = now.Add(-time.Minute)
+			pool := &Pool{
+				Proxies:  []*Proxy{recent, weekOld},
+				Scoring:  ScoringConfig{HalfLifeSeconds: halfLifeHour},
+				Strategy: strategy,
+			}
+
+			minScore := 5.0
+			got, err := pool.AllocateWith(t.Context(), AllocationRequest{MinScore: &minScore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Proxy != weekOld {
+				t.Fatalf("expected the proxy that failed a week ago, got %s", got.Proxy.URL)
+			}
+			if recent.Score > 0 {
+				t.Fatalf("expected the recent failure to still count, got %.2f", recent.Score)
+			}
+		})
+	}
+}
+
+func TestStats_ShowAgedScores(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8888")
+	p.Scoring = ScoringConfig{HalfLifeSeconds: halfLifeHour}
+	p.Score = -5
+	p.ScoredAt = time.Now().Add(-2 * time.Hour)
+	old := time.Now().Add(-2 * time.Hour)
+	p.DomainScores = map[string]DomainScore{
+		"example.com": {Domain: "example.com", Score: -5, UpdatedAt: old},
+	}
+	pool := &Pool{Proxies: []*Proxy{p}}
+
+	stats := pool.Publish().Stats
+	if stats[0].Score < 3 || stats[0].Score > 3.5 {
+		t.Fatalf("expected about a quarter of the distance left after two half-lives, got %.2f", stats[0].Score)
+	}
+	if ds := p.Domains()[0]; ds.Score < 3 || ds.Score > 3.5 || !ds.UpdatedAt.Equal(old) {
+		t.Fatalf("expected the domain score to age too, got %+v", ds)
+	}
+}
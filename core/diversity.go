@@ -0,0 +1,370 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ASNTag is the proxy tag holding its autonomous system number, e.g.
+// asn:AS13335. Providers' exports often carry it; it is optional.
+const ASNTag = "asn"
+
+const (
+	defaultPrefixV4 = 24
+	defaultPrefixV6 = 48
+)
+
+// DiversityConfig spreads allocations across subnets, so that several
+// proxies from the same /24 are not handed out back to back and get the
+// whole range banned.
+type DiversityConfig struct {
+	// PrefixV4 and PrefixV6 are the prefix lengths that group proxies
+	// into subnets. Zero means /24 and /48.
+	PrefixV4 int `yaml:"prefix_v4"`
+	PrefixV6 int `yaml:"prefix_v6"`
+	// WindowSeconds keeps allocation away from a subnet for that long
+	// after it was handed out, as long as a proxy from another subnet is
+	// eligible. Zero disables the window; batches are spread regardless.
+	WindowSeconds int `yaml:"window_seconds"`
+	// ByASN spreads proxies carrying an asn tag by ASN instead of subnet.
+	ByASN bool `yaml:"by_asn"`
+}
+
+func (c DiversityConfig) prefixV4() int {
+	if c.PrefixV4 <= 0 || c.PrefixV4 > 32 {
+		return defaultPrefixV4
+	}
+	return c.PrefixV4
+}
+
+func (c DiversityConfig) prefixV6() int {
+	if c.PrefixV6 <= 0 || c.PrefixV6 > 128 {
+		return defaultPrefixV6
+	}
+	return c.PrefixV6
+}
+
+func (c DiversityConfig) window() time.Duration {
+	return time.Duration(max(c.WindowSeconds, 0)) * time.Second
+}
+
+// Subnet returns the subnet addr belongs to, e.g. 203.0.113.0/24.
+func (c DiversityConfig) Subnet(addr netip.Addr) netip.Prefix {
+	bits := c.prefixV6()
+	if addr.Is4() {
+		bits = c.prefixV4()
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// ProxyAddr returns the IP address in the host of a proxy URL, or the
+// zero Addr when the host is a name.
+func ProxyAddr(rawURL string) netip.Addr {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr, err := netip.ParseAddr(u.Hostname())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
+
+// ParseASN normalizes an ASN written as "AS13335", "as13335" or "13335"
+// to "AS13335". It returns "" when s is not an ASN.
+func ParseASN(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return ""
+	}
+	return "AS" + strconv.FormatUint(n, 10)
+}
+
+// Addr returns ProxyAddr(p.URL), computed once.
+func (p *Proxy) Addr() netip.Addr {
+	p.addrOnce.Do(func() { p.addr = ProxyAddr(p.URL) })
+	return p.addr
+}
+
+// subnetKey is a proxy's subnet under the prefix lengths v4 and v6.
+type subnetKey struct {
+	v4, v6 int
+	key    string
+}
+
+// subnet returns the subnet of proxy under the pool's prefixes. Proxies
+// given by host name form a group of their own, named after the host.
+// The result is cached on the proxy until the prefixes change.
+func (p *Pool) subnet(proxy *Proxy) string {
+	v4, v6 := p.Diversity.prefixV4(), p.Diversity.prefixV6()
+	if k := proxy.subnet.Load(); k != nil && k.v4 == v4 && k.v6 == v6 {
+		return k.key
+	}
+	key := proxy.URL
+	if addr := proxy.Addr(); addr.IsValid() {
+		key = p.Diversity.Subnet(addr).String()
+	} else if u, err := url.Parse(proxy.URL); err == nil {
+		key = strings.ToLower(u.Hostname())
+	}
+	proxy.subnet.Store(&subnetKey{v4: v4, v6: v6, key: key})
+	return key
+}
+
+// diversityKey is the group allocation spreads proxy away from: its ASN
+// with ByASN, else its subnet. Must be called with proxy.mu held.
+func (p *Pool) diversityKey(proxy *Proxy) string {
+	if p.Diversity.ByASN {
+		if asn := ParseASN(proxy.Tags[ASNTag]); asn != "" {
+			return asn
+		}
+	}
+	return p.subnet(proxy)
+}
+
+// diversityRetention is how long lastUsed keeps a group when the window
+// is shorter, so subnet stats can still show recent allocations.
+const diversityRetention = time.Hour
+
+// diversityState remembers when each subnet (and ASN) was last handed
+// out. It is guarded by the pool mutex and holds one entry per group
+// allocated from within the retention period, see prune.
+type diversityState struct {
+	lastUsed map[string]time.Time
+	prunedAt time.Time
+}
+
+// prune drops the groups last handed out longer than retention ago, at
+// most once per retention, so lastUsed doesn't grow with every subnet
+// that ever passed through the pool.
+func (d *diversityState) prune(retention time.Duration, now time.Time) {
+	if now.Sub(d.prunedAt) < retention {
+		return
+	}
+	d.prunedAt = now
+	maps.DeleteFunc(d.lastUsed, func(_ string, last time.Time) bool {
+		return now.Sub(last) >= retention
+	})
+}
+
+// diversifying reports whether allocation for req has groups to avoid.
+// Must be called with p.mu held.
+func (p *Pool) diversifying(req *AllocationRequest) bool {
+	return p.Diversity.window() > 0 || len(req.avoid) > 0
+}
+
+// avoided reports whether allocation for req should stay away from the
+// group key: it is part of the current batch or was handed out within
+// the window. Must be called with p.mu held.
+func (p *Pool) avoided(req *AllocationRequest, key string, now time.Time) bool {
+	if req.avoid[key] {
+		return true
+	}
+	window := p.Diversity.window()
+	if window <= 0 {
+		return false
+	}
+	last, ok := p.diversity.lastUsed[key]
+	return ok && now.Sub(last) < window
+}
+
+// noteAllocated records that proxy was just handed out, for the
+// diversity window. It does nothing when the pool has none. Must be
+// called with p.mu held, and with proxy.mu held.
+func (p *Pool) noteAllocated(proxy *Proxy, now time.Time) {
+	if p.Diversity.window() <= 0 {
+		return
+	}
+	if p.diversity.lastUsed == nil {
+		p.diversity.lastUsed = make(map[string]time.Time)
+	}
+	p.diversity.prune(max(p.Diversity.window(), diversityRetention), now)
+	subnet := p.subnet(proxy)
+	p.diversity.lastUsed[subnet] = now
+	if key := p.diversityKey(proxy); key != subnet {
+		p.diversity.lastUsed[key] = now
+	}
+}
+
+// narrowToDiverse keeps only the candidates outside the groups req
+// should avoid when there is at least one of them. Must be called with
+// p.mu held.
+func (p *Pool) narrowToDiverse(req *AllocationRequest, candidates []Candidate, now time.Time) []Candidate {
+	if !p.diversifying(req) {
+		return candidates
+	}
+	out := make([]Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		c.Proxy.mu.Lock()
+		key := p.diversityKey(c.Proxy)
+		c.Proxy.mu.Unlock()
+		if !p.avoided(req, key, now) {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return candidates
+	}
+	return out
+}
+
+// AllocateBatch allocates up to n distinct proxies satisfying req,
+// spreading them across subnets (or ASNs, see DiversityConfig.ByASN):
+// a subnet only appears twice once every eligible subnet has been used.
+// It stops early when the pool runs out of eligible proxies and only
+// returns an error when it could not allocate any. Batches are not
+// sticky, so req.SessionKey is ignored.
+//
+// Thread-safe.
+func (p *Pool) AllocateBatch(ctx context.Context, req AllocationRequest, n int) ([]*Proxy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req.Domain = NormalizeDomain(req.Domain)
+	req.SessionKey = ""
+	req.excluded = make(map[string]bool, len(req.Exclude)+n)
+	for _, u := range req.Exclude {
+		req.excluded[u] = true
+	}
+	req.Exclude = nil
+	req.avoid = make(map[string]bool, n)
+
+	// groups counts the proxies not excluded in each group, and remaining
+	// the groups among them the batch hasn't used yet. Once it hits zero
+	// every group has been used and the batch starts reusing them.
+	groups := p.groupSizes(&req)
+	remaining := len(groups)
+
+	proxies := make([]*Proxy, 0, n)
+	for len(proxies) < n {
+		proxy, err := p.allocate(false, &req)
+		if err != nil {
+			if len(proxies) == 0 {
+				return nil, err
+			}
+			break
+		}
+		proxies = append(proxies, proxy)
+		req.excluded[proxy.URL] = true
+
+		proxy.mu.Lock()
+		key := p.diversityKey(proxy)
+		proxy.mu.Unlock()
+		if size, ok := groups[key]; ok {
+			if !req.avoid[key] {
+				remaining--
+			}
+			if size > 1 {
+				groups[key] = size - 1
+			} else {
+				delete(groups, key)
+			}
+		}
+		req.avoid[key] = true
+		if remaining == 0 {
+			clear(req.avoid)
+			remaining = len(groups)
+		}
+	}
+	return proxies, nil
+}
+
+// groupSizes counts the proxies req doesn't exclude in each diversity
+// group.
+func (p *Pool) groupSizes(req *AllocationRequest) map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	groups := make(map[string]int)
+	for _, proxy := range p.Proxies {
+		if req.excludes(proxy.URL) {
+			continue
+		}
+		proxy.mu.Lock()
+		groups[p.diversityKey(proxy)]++
+		proxy.mu.Unlock()
+	}
+	return groups
+}
+
+// SubnetStats are the diversity metrics of one subnet of a pool.
+type SubnetStats struct {
+	// Subnet is the prefix, e.g. 203.0.113.0/24, or the host name for
+	// proxies not given by IP.
+	Subnet  string   `json:"subnet"`
+	ASNs    []string `json:"asns"`
+	Proxies int      `json:"proxies"`
+	Alive   int      `json:"alive"`
+	// UsageCount and Share are the allocations that went to the subnet,
+	// and their share of the pool's allocations.
+	UsageCount   int     `json:"usage_count"`
+	Share        float64 `json:"share"`
+	ActiveLeases int     `json:"active_leases"`
+	// LastAllocated is only tracked when the pool has a diversity
+	// window. It is empty when the subnet has not been handed out within
+	// the last hour, or the window if that is longer.
+	LastAllocated string `json:"last_allocated,omitempty"`
+}
+
+// subnetStats groups the per-proxy stats of a snapshot by subnet,
+// largest subnets first. lastUsed is a copy of the pool's diversity
+// state.
+func subnetStats(stats []ProxyStats, lastUsed map[string]time.Time) []SubnetStats {
+	bySubnet := map[string]*SubnetStats{}
+	total := 0
+	for _, s := range stats {
+		st, ok := bySubnet[s.Subnet]
+		if !ok {
+			st = &SubnetStats{Subnet: s.Subnet, ASNs: []string{}}
+			if last, ok := lastUsed[s.Subnet]; ok {
+				st.LastAllocated = last.Format(time.RFC3339)
+			}
+			bySubnet[s.Subnet] = st
+		}
+		st.Proxies++
+		if s.Alive {
+			st.Alive++
+		}
+		st.UsageCount += s.UsageCount
+		st.ActiveLeases += s.ActiveLeases
+		if s.ASN != "" && !slices.Contains(st.ASNs, s.ASN) {
+			st.ASNs = append(st.ASNs, s.ASN)
+		}
+		total += s.UsageCount
+	}
+
+	out := make([]SubnetStats, 0, len(bySubnet))
+	for _, st := range bySubnet {
+		if total > 0 {
+			st.Share = float64(st.UsageCount) / float64(total)
+		}
+		slices.Sort(st.ASNs)
+		out = append(out, *st)
+	}
+	slices.SortFunc(out, func(a, b SubnetStats) int {
+		if c := cmp.Compare(b.Proxies, a.Proxies); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Subnet, b.Subnet)
+	})
+	return out
+}
+
+func addrString(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
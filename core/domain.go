@@ -0,0 +1,117 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DomainScore is a proxy's score against one target domain. It moves
+// with client feedback that names the domain, using the pool's scoring
+// model, and starts from the global Score the first time the domain is
+// reported.
+type DomainScore struct {
+	Domain       string    `json:"domain"`
+	Score        float64   `json:"score"`
+	SuccessCount int       `json:"success_count"`
+	FailCount    int       `json:"fail_count"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// AgedAt is when Score was last aged, see ScoringConfig.HalfLifeSeconds.
+	AgedAt time.Time `json:"-"`
+}
+
+// NormalizeDomain reduces a host name or URL to the lower-case host used
+// to key domain scores: "https://WWW.Example.com:443/x" becomes
+// "www.example.com".
+func NormalizeDomain(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil {
+			s = u.Host
+		}
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return strings.TrimSuffix(strings.ToLower(s), ".")
+}
+
+// Domains returns the proxy's per-domain scores sorted by domain.
+func (p *Proxy) Domains() []DomainScore {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.age(time.Now())
+
+	out := make([]DomainScore, 0, len(p.DomainScores))
+	for _, ds := range p.DomainScores {
+		out = append(out, ds)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out
+}
+
+// DomainScores returns the per-domain scores of the proxy with the given
+// URL.
+func (p *Pool) DomainScores(proxyURL string) ([]DomainScore, error) {
+	proxy := p.find(proxyURL)
+	if proxy == nil {
+		return nil, ErrProxyNotFound
+	}
+	return proxy.Domains(), nil
+}
+
+// scoreFor returns the score to rank the proxy on for domain: the
+// domain-specific one when the domain has been reported, the global
+// Score otherwise. Must be called with p.mu held.
+func (p *Proxy) scoreFor(domain string) float64 {
+	if domain != "" {
+		if ds, ok := p.DomainScores[domain]; ok {
+			return ds.Score
+		}
+	}
+	return p.Score
+}
+
+// recordDomain applies a client outcome to the domain score.
+// Must be called with p.mu held.
+func (p *Proxy) recordDomain(domain string, success bool, weight float64) {
+	ds, ok := p.DomainScores[domain]
+	if !ok {
+		ds = DomainScore{Domain: domain, Score: p.Score}
+	}
+
+	if success {
+		ds.SuccessCount++
+	} else {
+		ds.FailCount++
+	}
+
+	scorer := p.domainScorer(domain, ds.Score)
+	ds.Score = scorer.Observe(ds.Score, ScoreOutcome{Success: success, Weight: weight, Failures: ds.FailCount})
+	ds.UpdatedAt = time.Now()
+	ds.AgedAt = ds.UpdatedAt
+
+	if p.DomainScores == nil {
+		p.DomainScores = make(map[string]DomainScore)
+	}
+	p.DomainScores[domain] = ds
+}
+
+// domainScorer returns the scoring model of the domain score, creating
+// it seeded with seed on first use. Must be called with p.mu held.
+func (p *Proxy) domainScorer(domain string, seed float64) Scorer {
+	scorer, ok := p.domainScorers[domain]
+	if !ok {
+		scorer = p.Scoring.newScorer(seed)
+		if p.domainScorers == nil {
+			p.domainScorers = make(map[string]Scorer)
+		}
+		p.domainScorers[domain] = scorer
+	}
+	return scorer
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := map[string]string{
+		"":                              "",
+		"example.com":                   "example.com",
+		"Example.COM.":                  "example.com",
+		"shop.example.com:8443":         "shop.example.com",
+		"https://WWW.Example.com:443/x": "www.example.com",
+		"http://example.com/path?q=1":   "example.com",
+		"  https://example.com  ":       "example.com",
+	}
+	for in, want := range cases {
+		if got := NormalizeDomain(in); got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestReport_DomainScoreStartsFromGlobal(t *testing.T) {
+	pool := newTestPool()
+	url := pool.Proxies[0].URL
+
+	if err := pool.Report(url, Outcome{Success: false, ErrorClass: ErrorClassBanned, Domain: "https://shop.example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	scores, err := pool.DomainScores(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 1 || scores[0].Domain != "shop.example.com" || scores[0].FailCount != 1 {
+		t.Fatalf("unexpected domain scores: %+v", scores)
+	}
+	if scores[0].Score >= 6 {
+		t.Fatalf("expected the domain score to drop below the starting 6, got %.2f", scores[0].Score)
+	}
+
+	if _, err := pool.DomainScores("http://unknown:1"); err != ErrProxyNotFound {
+		t.Fatalf("expected ErrProxyNotFound, got %v", err)
+	}
+}
+
+func TestAllocateWith_RanksOnDomainScore(t *testing.T) {
+	pool := newTestPool()
+	pool.Proxies[0].Score = 9
+	pool.Proxies[1].Score = 5
+	pool.Proxies[0].DomainScores = map[string]DomainScore{
+		"shop.example.com": {Domain: "shop.example.com", Score: -2},
+	}
+
+	a, err := pool.AllocateWith(context.Background(), AllocationRequest{Domain: "SHOP.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Proxy != pool.Proxies[1] {
+		t.Fatalf("expected the proxy without a bad domain score, got %s", a.Proxy.URL)
+	}
+
+	a, err = pool.AllocateWith(context.Background(), AllocationRequest{Domain: "other.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Proxy != pool.Proxies[0] {
+		t.Fatalf("expected fallback to the global score, got %s", a.Proxy.URL)
+	}
+
+	min := 0.0
+	_, err = pool.AllocateWith(context.Background(), AllocationRequest{
+		Domain:   "shop.example.com",
+		MinScore: &min,
+		Exclude:  []string{pool.Proxies[1].URL},
+	})
+	if err != ErrNoMatch {
+		t.Fatalf("expected MinScore to apply to the domain score, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultExpiryWarning is how long before its ExpiresAt a proxy counts
+// as expiring when the pool sets no ExpiryWarning.
+const defaultExpiryWarning = 72 * time.Hour
+
+// expiryLayouts are the formats ParseExpiry accepts, most specific first.
+var expiryLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseExpiry parses an expiry date as providers write it: RFC 3339, or
+// a date with an optional time, taken as UTC.
+func ParseExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range expiryLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry date %q, expected YYYY-MM-DD or RFC 3339", s)
+}
+
+// expired reports whether the proxy is past its ExpiresAt. Must be
+// called with p.mu held.
+func (p *Proxy) expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+func (p *Pool) expiryWarning() time.Duration {
+	if p.ExpiryWarning <= 0 {
+		return defaultExpiryWarning
+	}
+	return p.ExpiryWarning
+}
+
+// expiring reports whether proxy expires within the pool's warning
+// window. Must be called with proxy.mu held.
+func (p *Pool) expiring(proxy *Proxy, now time.Time) bool {
+	return !proxy.ExpiresAt.IsZero() && !proxy.expired(now) &&
+		proxy.ExpiresAt.Sub(now) <= p.expiryWarning()
+}
+
+// RetireExpired takes the proxies past their ExpiresAt out of the pool
+// and returns them. Allocation already skips them; retiring also drops
+// their sticky sessions and has the ProxyStore keep them as expired
+// instead of deleting them, so their history stays. Outstanding leases
+// stay valid until released or expired.
+func (p *Pool) RetireExpired() []*Proxy {
+	now := time.Now()
+
+	p.mu.Lock()
+	var expired []*Proxy
+	remaining := make([]*Proxy, 0, len(p.Proxies))
+	for _, proxy := range p.Proxies {
+		proxy.mu.Lock()
+		gone := proxy.expired(now)
+		proxy.mu.Unlock()
+		if gone {
+			expired = append(expired, proxy)
+		} else {
+			remaining = append(remaining, proxy)
+		}
+	}
+	if len(expired) == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	p.setProxies(remaining)
+	p.mu.Unlock()
+
+	for _, proxy := range expired {
+		proxy.markRemoved()
+		p.dropSessions(proxy.URL)
+		proxy.Close()
+		if p.ProxyStore != nil {
+			if err := p.ProxyStore.RetireProxy(proxy.URL); err != nil {
+				log.Printf("[warn] failed to retire expired proxy %s: %v", proxy.URL, err)
+			}
+		}
+	}
+	return expired
+}
+
+// revive carries the history of a retired proxy over to proxy, a new
+// proxy with the same URL: score, counters, breaker state and domain
+// scores come from the stored proxy, settings from proxy. Probation
+// starts over. Nothing changes when the URL was never retired.
+func (p *Pool) revive(proxy *Proxy) {
+	if p.ProxyStore == nil {
+		return
+	}
+	old, err := p.ProxyStore.RetiredProxy(proxy.URL)
+	if err != nil {
+		log.Printf("[warn] failed to load retired proxy %s: %v", proxy.URL, err)
+		return
+	}
+	if old == nil {
+		return
+	}
+
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	proxy.Score = old.Score
+	proxy.ScoredAt = old.ScoredAt
+	proxy.scoreModel = nil
+	proxy.UsageCount = old.UsageCount
+	proxy.FailCount = old.FailCount
+	proxy.SuccessCount = old.SuccessCount
+	proxy.LatencyMS = old.LatencyMS
+	proxy.LastTest = old.LastTest
+	proxy.State = old.State
+	proxy.StateChangedAt = old.StateChangedAt
+	proxy.ConsecutiveFailures = old.ConsecutiveFailures
+	proxy.DomainScores = old.DomainScores
+	proxy.scoreChanged()
+}
+
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
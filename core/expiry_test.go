@@ -0,0 +1,207 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	for in, want := range map[string]string{
+		"2027-01-31":                "2027-01-31T00:00:00Z",
+		"2027-01-31 18:30":          "2027-01-31T18:30:00Z",
+		" 2027-01-31 18:30:05 ":     "2027-01-31T18:30:05Z",
+		"2027-01-31T18:30:05":       "2027-01-31T18:30:05Z",
+		"2027-01-31T18:30:05+02:00": "2027-01-31T18:30:05+02:00",
+	} {
+		got, err := ParseExpiry(in)
+		if err != nil {
+			t.Errorf("ParseExpiry(%q): %v", in, err)
+			continue
+		}
+		if got.Format(time.RFC3339) != want {
+			t.Errorf("ParseExpiry(%q) = %s, want %s", in, got.Format(time.RFC3339), want)
+		}
+	}
+	if _, err := ParseExpiry("31/01/2027"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestAllocate_SkipsExpired(t *testing.T) {
+	for name, strategy := range map[string]AllocationStrategy{
+		"indexed": nil,
+		"scan":    LeastLatency{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expired := newTestProxy("http://127.0.0.1:8888")
+			expired.Score = 10
+			expired.ExpiresAt = time.Now().Add(-time.Minute)
+			current := newTestProxy("http://127.0.0.1:8889")
+			current.ExpiresAt = time.Now().Add(time.Hour)
+			pool := &Pool{Proxies: []*Proxy{expired, current}, Strategy: strategy}
+
+			for range 3 {
+				got, err := pool.Allocate()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != current {
+					t.Fatalf("expected the proxy that has not expired, got %s", got.URL)
+				}
+			}
+
+			current.ExpiresAt = time.Now().Add(-time.Second)
+			if _, err := pool.Allocate(); !errors.Is(err, ErrNoAliveProxies) {
+				t.Fatalf("expected ErrNoAliveProxies once every proxy expired, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRetireExpired(t *testing.T) {
+	expired := newTestProxy("http://127.0.0.1:8888")
+	expired.Score = 10
+	current := newTestProxy("http://127.0.0.1:8889")
+	store := &memProxyStore{}
+	pool := &Pool{Proxies: []*Proxy{expired, current}, ProxyStore: store}
+
+	got, err := pool.AllocateWith(t.Context(), AllocationRequest{SessionKey: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Proxy != expired {
+		t.Fatalf("expected the best proxy for the session, got %s", got.Proxy.URL)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	retired := pool.RetireExpired()
+	if len(retired) != 1 || retired[0] != expired {
+		t.Fatalf("expected the expired proxy to be retired, got %v", retired)
+	}
+	if all := pool.All(); len(all) != 1 || all[0] != current {
+		t.Fatalf("expected only the current proxy to remain, got %v", all)
+	}
+	if len(store.retired) != 1 || store.retired[0] != expired.URL || len(store.deleted) != 0 {
+		t.Fatalf("expected the store to retire, not delete, got retired %v deleted %v", store.retired, store.deleted)
+	}
+	if sessions := pool.Sessions(); len(sessions) != 0 {
+		t.Fatalf("expected the session on the expired proxy to be dropped, got %+v", sessions)
+	}
+	if got := pool.RetireExpired(); got != nil {
+		t.Fatalf("expected nothing left to retire, got %v", got)
+	}
+}
+
+func TestStats_Expiring(t *testing.T) {
+	soon := newTestProxy("http://127.0.0.1:8888")
+	soon.ExpiresAt = time.Now().Add(2 * time.Hour)
+	later := newTestProxy("http://127.0.0.1:8889")
+	later.ExpiresAt = time.Now().Add(72 * time.Hour)
+	never := newTestProxy("http://127.0.0.1:8890")
+	pool := &Pool{Proxies: []*Proxy{soon, later, never}, ExpiryWarning: 24 * time.Hour}
+
+	stats := pool.Publish().Stats
+	if !stats[0].Expiring || stats[0].ExpiresAt != soon.ExpiresAt.Format(time.RFC3339) {
+		t.Fatalf("expected the proxy expiring in 2h to be flagged, got %+v", stats[0])
+	}
+	if stats[1].Expiring || stats[1].ExpiresAt == "" {
+		t.Fatalf("expected the proxy expiring in 3 days to be outside the window, got %+v", stats[1])
+	}
+	if stats[2].Expiring || stats[2].ExpiresAt != "" {
+		t.Fatalf("expected no expiry, got %+v", stats[2])
+	}
+	if c := pool.Counts(); c.Expiring != 1 {
+		t.Fatalf("expected 1 expiring proxy, got %d", c.Expiring)
+	}
+
+	// The default window is 72 hours.
+	pool.ExpiryWarning = 0
+	if c := pool.Counts(); c.Expiring != 2 {
+		t.Fatalf("expected 2 expiring proxies with the default window, got %d", c.Expiring)
+	}
+}
+
+func TestParseProxyList_Expiry(t *testing.T) {
+	csv := `ip,port,expires
+1.2.3.4,8080,2027-01-31
+1.2.3.5,8080,
+1.2.3.6,8080,soon`
+	entries, errs, err := ParseProxyList([]byte(csv), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(errs) != 1 || errs[0].Line != 4 {
+		t.Fatalf("unexpected result: %+v %+v", entries, errs)
+	}
+	if got := entries[0].Config.ExpiresAt.Format(time.DateOnly); got != "2027-01-31" {
+		t.Fatalf("expected the expiry column to be read, got %s", got)
+	}
+	if !entries[1].Config.ExpiresAt.IsZero() {
+		t.Fatalf("expected no expiry for an empty cell, got %v", entries[1].Config.ExpiresAt)
+	}
+
+	json := `[{"ip": "1.2.3.4", "port": 8080, "expires_at": "2027-01-31T12:00:00Z"}]`
+	entries, _, err = ParseProxyList([]byte(json), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries[0].Config.ExpiresAt.Format(time.RFC3339); got != "2027-01-31T12:00:00Z" {
+		t.Fatalf("expected the expires_at field to be read, got %s", got)
+	}
+}
+
+func TestImport_Expiry(t *testing.T) {
+	pool := newCheckedPool(t, 1)
+	host := strings.TrimPrefix(pool.All()[0].URL, "http://user0@")
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	list := strings.Join([]string{
+		"url,expires_at",
+		"http://a@" + host + ",",
+		"http://b@" + host + "," + past,
+	}, "\n")
+
+	batch := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	res, err := pool.Import([]byte(list), ImportOptions{ExpiresAt: batch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Added) != 1 || len(res.Errors) != 1 || res.Errors[0].Line != 3 {
+		t.Fatalf("expected the expired entry to be rejected, got %+v", res)
+	}
+	if added := pool.find(res.Added[0]); added == nil || !added.ExpiresAt.Equal(batch) {
+		t.Fatalf("expected the batch expiry on the entry without one, got %+v", added)
+	}
+}
+
+func TestAdd_RevivesRetiredHistory(t *testing.T) {
+	pool := newCheckedPool(t, 1)
+	store := &memProxyStore{}
+	pool.ProxyStore = store
+
+	proxy := pool.All()[0]
+	proxy.mu.Lock()
+	proxy.UsageCount = 40
+	proxy.FailCount = 7
+	proxy.ExpiresAt = time.Now().Add(-time.Minute)
+	proxy.mu.Unlock()
+	if err := store.SaveProxy(proxy); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.RetireExpired()) != 1 {
+		t.Fatal("expected the proxy to be retired")
+	}
+
+	renewed, err := pool.Add(ProxyConfig{URL: proxy.URL, ExpiresAt: time.Now().Add(24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := renewed.Snapshot()
+	if snap.UsageCount != 40 || snap.FailCount != 7 || snap.SuccessCount == 0 {
+		t.Fatalf("expected the retired counters plus the new check, got %+v", snap)
+	}
+	if saved := store.saved[proxy.URL]; saved.UsageCount != 40 {
+		t.Fatalf("expected the revived state to be saved, got %+v", saved)
+	}
+}
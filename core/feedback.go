@@ -0,0 +1,128 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrProxyNotFound = errors.New("proxy not found")
+
+// Error classes clients can attach to a failed Outcome.
+// Unknown classes are accepted and weighted like ErrorClassOther.
+const (
+	ErrorClassTimeout    = "timeout"
+	ErrorClassConnection = "connection"
+	ErrorClassAuth       = "auth"
+	ErrorClassBanned     = "banned"
+	ErrorClassOther      = "other"
+)
+
+// Outcome is what a client observed when it actually used a proxy.
+// LatencyMS of a success, when set, joins the proxy's latency samples
+// like a health check's; failures don't, a timeout says nothing about
+// speed. Domain, when set, names the target site; the outcome then also
+// moves the proxy's score for that domain.
+type Outcome struct {
+	Success    bool
+	LatencyMS  int
+	ErrorClass string
+	Domain     string
+}
+
+// FeedbackConfig controls how much client reports move a proxy's score
+// compared to a health check, which always counts with weight 1.
+type FeedbackConfig struct {
+	SuccessWeight float64            `yaml:"success_weight"`
+	FailureWeight float64            `yaml:"failure_weight"`
+	ErrorWeights  map[string]float64 `yaml:"error_weights"`
+}
+
+const (
+	defaultFeedbackSuccessWeight = 0.5
+	defaultFeedbackFailureWeight = 1.0
+)
+
+func (c FeedbackConfig) successWeight() float64 {
+	if c.SuccessWeight <= 0 {
+		return defaultFeedbackSuccessWeight
+	}
+	return c.SuccessWeight
+}
+
+func (c FeedbackConfig) failureWeight(class string) float64 {
+	w := c.FailureWeight
+	if w <= 0 {
+		w = defaultFeedbackFailureWeight
+	}
+	if cw, ok := c.ErrorWeights[class]; ok && cw > 0 {
+		w *= cw
+	}
+	return w
+}
+
+// Report feeds a client-observed outcome for the proxy with the given URL
+// into its score.
+func (p *Pool) Report(proxyURL string, o Outcome) error {
+	proxy := p.find(proxyURL)
+	if proxy == nil {
+		return ErrProxyNotFound
+	}
+	p.applyOutcome(proxy, o)
+	return nil
+}
+
+// ReleaseWithOutcome releases a lease and reports how the proxy behaved
+// while it was held.
+func (p *Pool) ReleaseWithOutcome(id string, o Outcome) error {
+	p.leaseMu.Lock()
+	lease, ok := p.leases[id]
+	p.leaseMu.Unlock()
+	if !ok {
+		return ErrLeaseNotFound
+	}
+
+	if err := p.Release(id); err != nil {
+		return err
+	}
+	p.applyOutcome(lease.Proxy, o)
+	return nil
+}
+
+func (p *Pool) applyOutcome(proxy *Proxy, o Outcome) {
+	defer p.touch()
+	if o.Success {
+		weight := p.Feedback.successWeight()
+		proxy.recordSuccess(o.LatencyMS, sourceClient, weight)
+		p.applyDomainOutcome(proxy, o, weight)
+		return
+	}
+
+	class := o.ErrorClass
+	if class == "" {
+		class = ErrorClassOther
+	}
+	weight := p.Feedback.failureWeight(class)
+	proxy.recordFailure(fmt.Sprintf("client reported %s", class), nil, sourceClient, weight)
+	p.applyDomainOutcome(proxy, o, weight)
+}
+
+func (p *Pool) applyDomainOutcome(proxy *Proxy, o Outcome, weight float64) {
+	domain := NormalizeDomain(o.Domain)
+	if domain == "" {
+		return
+	}
+	proxy.mu.Lock()
+	proxy.recordDomain(domain, o.Success, weight)
+	proxy.mu.Unlock()
+}
+
+func (p *Pool) find(proxyURL string) *Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proxy := range p.Proxies {
+		if proxy.URL == proxyURL {
+			return proxy
+		}
+	}
+	return nil
+}
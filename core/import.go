@@ -0,0 +1,601 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy list formats understood by ParseProxyList.
+const (
+	ImportFormatAuto  = "auto"
+	ImportFormatLines = "lines"
+	ImportFormatCSV   = "csv"
+	ImportFormatJSON  = "json"
+)
+
+// SourceTag is the tag Import sets to ImportOptions.Source.
+const SourceTag = "source"
+
+// importCheckConcurrency bounds how many imported proxies are health
+// checked at once.
+const importCheckConcurrency = 32
+
+var supportedSchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// ImportOptions controls ParseProxyList and Import.
+type ImportOptions struct {
+	// Format is one of the ImportFormat constants; empty means auto.
+	Format string
+	// DefaultScheme is used for entries without one. Empty means http.
+	DefaultScheme string
+	// Source, when set, is stored as the SourceTag tag of every
+	// imported proxy.
+	Source string
+	// ExpiresAt, when set, is the expiry of entries that don't carry
+	// their own.
+	ExpiresAt time.Time
+}
+
+// ImportEntry is one proxy parsed from a list. Line is the 1-based line
+// of the entry, or its position in the array for JSON input.
+type ImportEntry struct {
+	Line   int
+	Config ProxyConfig
+}
+
+// ImportError reports an entry that could not be imported.
+type ImportError struct {
+	Line   int    `json:"line"`
+	Input  string `json:"input"`
+	Reason string `json:"error"`
+}
+
+// ImportResult summarizes an Import. Duplicates lists entries that were
+// already in the pool or earlier in the list.
+type ImportResult struct {
+	Added      []string      `json:"added"`
+	Duplicates []string      `json:"duplicates"`
+	Errors     []ImportError `json:"errors"`
+}
+
+// ParseProxyList reads a provider proxy list and returns its entries as
+// normalized proxy URLs. It understands plain lines (ip:port,
+// ip:port:user:pass, user:pass@ip:port or full URLs), CSV exports with or
+// without a header row, and JSON arrays of strings or objects. Entries
+// that can't be parsed are returned as ImportErrors; the error is only
+// set when the input as a whole is unusable.
+func ParseProxyList(data []byte, opts ImportOptions) ([]ImportEntry, []ImportError, error) {
+	scheme := strings.ToLower(opts.DefaultScheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	if !supportedSchemes[scheme] {
+		return nil, nil, fmt.Errorf("unsupported default scheme %q", opts.DefaultScheme)
+	}
+
+	format := opts.Format
+	if format == "" || format == ImportFormatAuto {
+		format = detectFormat(data)
+	}
+
+	switch format {
+	case ImportFormatLines:
+		entries, errs := parseLines(data, scheme)
+		return entries, errs, nil
+	case ImportFormatCSV:
+		return parseCSV(data, scheme)
+	case ImportFormatJSON:
+		return parseJSON(data, scheme)
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+}
+
+// detectFormat guesses the format of a proxy list: JSON when it is a
+// valid JSON array or object, CSV when the first entry has a comma, and
+// plain lines otherwise.
+func detectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') && json.Valid(trimmed) {
+		return ImportFormatJSON
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, ",") {
+			return ImportFormatCSV
+		}
+		break
+	}
+	return ImportFormatLines
+}
+
+func parseLines(data []byte, scheme string) ([]ImportEntry, []ImportError) {
+	var entries []ImportEntry
+	var errs []ImportError
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := normalizeProxyURL(line, scheme)
+		if err != nil {
+			errs = append(errs, ImportError{Line: n, Input: line, Reason: err.Error()})
+			continue
+		}
+		entries = append(entries, ImportEntry{Line: n, Config: ProxyConfig{URL: u}})
+	}
+	return entries, errs
+}
+
+// csvColumns maps the header names providers use to the proxy fields.
+var csvColumns = map[string]string{
+	"url": "url", "proxy": "url",
+	"ip": "host", "host": "host", "address": "host",
+	"port":     "port",
+	"username": "user", "user": "user", "login": "user",
+	"password": "pass", "pass": "pass",
+	"protocol": "scheme", "scheme": "scheme", "type": "scheme",
+	"expires_at": "expires", "expires": "expires", "expiry": "expires", "expiration": "expires",
+}
+
+func parseCSV(data []byte, scheme string) ([]ImportEntry, []ImportError, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var entries []ImportEntry
+	var errs []ImportError
+	var header map[string]int
+
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				errs = append(errs, ImportError{Line: perr.Line, Reason: perr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		input := strings.Join(record, ",")
+
+		if first {
+			if h := csvHeader(record); h != nil {
+				header = h
+				continue
+			}
+		}
+
+		var pc ProxyConfig
+		if header != nil {
+			pc, err = csvRecordConfig(record, header, scheme)
+		} else {
+			pc.URL, err = csvPositionalURL(record, scheme)
+		}
+		if err != nil {
+			errs = append(errs, ImportError{Line: line, Input: input, Reason: err.Error()})
+			continue
+		}
+		entries = append(entries, ImportEntry{Line: line, Config: pc})
+	}
+	return entries, errs, nil
+}
+
+// csvHeader returns the field positions of a header row, or nil when
+// record has no known column name and is data.
+func csvHeader(record []string) map[string]int {
+	header := map[string]int{}
+	for i, name := range record {
+		if field, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, dup := header[field]; !dup {
+				header[field] = i
+			}
+		}
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
+}
+
+func csvRecordConfig(record []string, header map[string]int, scheme string) (ProxyConfig, error) {
+	get := func(field string) string {
+		if i, ok := header[field]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	if s := get("scheme"); s != "" {
+		scheme = strings.ToLower(s)
+	}
+
+	var pc ProxyConfig
+	var err error
+	if u := get("url"); u != "" {
+		pc.URL, err = normalizeProxyURL(u, scheme)
+	} else {
+		pc.URL, err = proxyURLFromParts(scheme, get("host"), get("port"), get("user"), get("pass"))
+	}
+	if err != nil {
+		return pc, err
+	}
+	pc.ExpiresAt, err = parseEntryExpiry(get("expires"))
+	return pc, err
+}
+
+// parseEntryExpiry parses the expiry of a list entry; empty means none.
+func parseEntryExpiry(s string) (time.Time, error) {
+	if strings.TrimSpace(s) == "" {
+		return time.Time{}, nil
+	}
+	return ParseExpiry(s)
+}
+
+// csvPositionalURL reads a header-less record as url, ip,port or
+// ip,port,user,pass.
+func csvPositionalURL(record []string, scheme string) (string, error) {
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	switch len(record) {
+	case 1:
+		return normalizeProxyURL(record[0], scheme)
+	case 2:
+		return proxyURLFromParts(scheme, record[0], record[1], "", "")
+	case 4:
+		return proxyURLFromParts(scheme, record[0], record[1], record[2], record[3])
+	default:
+		return "", fmt.Errorf("expected ip,port or ip,port,user,pass, got %d columns", len(record))
+	}
+}
+
+// jsonProxy is an object entry of a JSON proxy list. Port may be a
+// number or a string.
+type jsonProxy struct {
+	URL       string            `json:"url"`
+	Proxy     string            `json:"proxy"`
+	IP        string            `json:"ip"`
+	Host      string            `json:"host"`
+	Port      json.RawMessage   `json:"port"`
+	Username  string            `json:"username"`
+	User      string            `json:"user"`
+	Password  string            `json:"password"`
+	Pass      string            `json:"pass"`
+	Protocol  string            `json:"protocol"`
+	Scheme    string            `json:"scheme"`
+	Tags      map[string]string `json:"tags"`
+	ExpiresAt string            `json:"expires_at"`
+	Expires   string            `json:"expires"`
+}
+
+func parseJSON(data []byte, scheme string) ([]ImportEntry, []ImportError, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapped struct {
+			Proxies []json.RawMessage `json:"proxies"`
+		}
+		if err2 := json.Unmarshal(data, &wrapped); err2 != nil || wrapped.Proxies == nil {
+			return nil, nil, errors.New("JSON input must be an array or an object with a proxies array")
+		}
+		items = wrapped.Proxies
+	}
+
+	var entries []ImportEntry
+	var errs []ImportError
+	for i, raw := range items {
+		n := i + 1
+		input := string(raw)
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			u, err := normalizeProxyURL(s, scheme)
+			if err != nil {
+				errs = append(errs, ImportError{Line: n, Input: s, Reason: err.Error()})
+				continue
+			}
+			entries = append(entries, ImportEntry{Line: n, Config: ProxyConfig{URL: u}})
+			continue
+		}
+
+		var obj jsonProxy
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			errs = append(errs, ImportError{Line: n, Input: input, Reason: "expected a string or an object"})
+			continue
+		}
+		u, err := obj.url(scheme)
+		if err != nil {
+			errs = append(errs, ImportError{Line: n, Input: input, Reason: err.Error()})
+			continue
+		}
+		expiresAt, err := parseEntryExpiry(cmp.Or(obj.ExpiresAt, obj.Expires))
+		if err != nil {
+			errs = append(errs, ImportError{Line: n, Input: input, Reason: err.Error()})
+			continue
+		}
+		entries = append(entries, ImportEntry{Line: n, Config: ProxyConfig{URL: u, Tags: obj.Tags, ExpiresAt: expiresAt}})
+	}
+	return entries, errs, nil
+}
+
+func (o jsonProxy) url(scheme string) (string, error) {
+	first := func(vals ...string) string {
+		for _, v := range vals {
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if s := first(o.Protocol, o.Scheme); s != "" {
+		scheme = strings.ToLower(s)
+	}
+	if u := first(o.URL, o.Proxy); u != "" {
+		return normalizeProxyURL(u, scheme)
+	}
+
+	var port string
+	if len(o.Port) > 0 {
+		var n json.Number
+		if err := json.Unmarshal(o.Port, &n); err == nil {
+			port = n.String()
+		} else if err := json.Unmarshal(o.Port, &port); err != nil {
+			return "", errors.New("port must be a number or a string")
+		}
+	}
+	return proxyURLFromParts(scheme, first(o.IP, o.Host), port, first(o.Username, o.User), first(o.Password, o.Pass))
+}
+
+// errProxyEntry is returned for list entries in none of the forms
+// normalizeProxyURL knows.
+var errProxyEntry = errors.New("expected ip:port, ip:port:user:pass, user:pass@ip:port or a URL")
+
+// normalizeProxyURL turns one proxy list entry into a proxy URL. Entries
+// without a scheme get scheme; the scheme and host are lower-cased. IPv6
+// hosts are written in brackets, e.g. [2001:db8::1]:8080.
+func normalizeProxyURL(s, scheme string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "://") {
+		return canonicalProxyURL(s)
+	}
+
+	if userinfo, hostport, ok := cutLast(s, "@"); ok {
+		user, pass, _ := strings.Cut(userinfo, ":")
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return "", errors.New("expected host:port after @")
+		}
+		return proxyURLFromParts(scheme, host, port, user, pass)
+	}
+
+	if strings.HasPrefix(s, "[") {
+		// [ipv6]:port, optionally followed by :user:pass.
+		end := strings.Index(s, "]")
+		if end < 0 || !strings.HasPrefix(s[end+1:], ":") {
+			return "", errProxyEntry
+		}
+		port, creds, hasCreds := strings.Cut(s[end+2:], ":")
+		user, pass, ok := strings.Cut(creds, ":")
+		if hasCreds && !ok {
+			return "", errProxyEntry
+		}
+		return proxyURLFromParts(scheme, s[1:end], port, user, pass)
+	}
+
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 2:
+		return proxyURLFromParts(scheme, parts[0], parts[1], "", "")
+	case len(parts) >= 4:
+		return proxyURLFromParts(scheme, parts[0], parts[1], parts[2], strings.Join(parts[3:], ":"))
+	default:
+		return "", errProxyEntry
+	}
+}
+
+func proxyURLFromParts(scheme, host, port, user, pass string) (string, error) {
+	if host == "" {
+		return "", errors.New("missing host")
+	}
+	if port == "" {
+		return "", errors.New("missing port")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, port)}
+	switch {
+	case user != "" && pass != "":
+		u.User = url.UserPassword(user, pass)
+	case user != "":
+		u.User = url.User(user)
+	}
+	return canonicalProxyURL(u.String())
+}
+
+// canonicalProxyURL checks that s is a usable proxy URL and returns it
+// with the scheme and host lower-cased.
+func canonicalProxyURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !supportedSchemes[u.Scheme] {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("missing host")
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid port %q", u.Port())
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+		return "", errors.New("proxy URL must not have a path or query")
+	}
+	u.Path = ""
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Import parses a proxy list (see ParseProxyList), health-checks the
+// entries that are not in the pool yet, adds them to the pool and
+// persists them. It only fails when the list as a whole can't be read;
+// problems with single entries are reported in the result.
+func (p *Pool) Import(data []byte, opts ImportOptions) (ImportResult, error) {
+	entries, parseErrs, err := ParseProxyList(data, opts)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+
+	res := ImportResult{Added: []string{}, Duplicates: []string{}, Errors: parseErrs}
+	if res.Errors == nil {
+		res.Errors = []ImportError{}
+	}
+
+	seen := make(map[string]bool)
+	for _, proxy := range p.All() {
+		seen[proxy.URL] = true
+		if u, err := canonicalProxyURL(proxy.URL); err == nil {
+			seen[u] = true
+		}
+	}
+
+	type candidate struct {
+		line  int
+		pc    ProxyConfig
+		proxy *Proxy
+	}
+	var pending []candidate
+	now := time.Now()
+	for _, e := range entries {
+		pc := e.Config
+		if seen[pc.URL] {
+			res.Duplicates = append(res.Duplicates, pc.URL)
+			continue
+		}
+		seen[pc.URL] = true
+
+		if pc.ExpiresAt.IsZero() {
+			pc.ExpiresAt = opts.ExpiresAt
+		}
+		if !pc.ExpiresAt.IsZero() && !pc.ExpiresAt.After(now) {
+			res.Errors = append(res.Errors, ImportError{Line: e.Line, Input: pc.URL, Reason: "expired at " + formatExpiry(pc.ExpiresAt)})
+			continue
+		}
+		if opts.Source != "" {
+			pc.Tags = maps.Clone(pc.Tags)
+			if pc.Tags == nil {
+				pc.Tags = make(map[string]string)
+			}
+			pc.Tags[SourceTag] = opts.Source
+		}
+		pending = append(pending, candidate{line: e.Line, pc: pc})
+	}
+
+	// Claim the URLs so a concurrent Add or Import of the same proxy
+	// can't be persisted over this one.
+	urls := make([]string, len(pending))
+	for i, c := range pending {
+		urls[i] = c.pc.URL
+	}
+	claimed := make(map[string]bool, len(urls))
+	for _, u := range p.reserve(urls...) {
+		claimed[u] = true
+	}
+	defer p.unreserve(slices.Collect(maps.Keys(claimed))...)
+
+	fresh := make([]candidate, 0, len(pending))
+	for _, c := range pending {
+		if !claimed[c.pc.URL] {
+			res.Duplicates = append(res.Duplicates, c.pc.URL)
+			continue
+		}
+		proxy, err := p.newProxy(c.pc)
+		if err != nil {
+			res.Errors = append(res.Errors, ImportError{Line: c.line, Input: c.pc.URL, Reason: err.Error()})
+			continue
+		}
+		p.revive(proxy)
+		c.proxy = proxy
+		fresh = append(fresh, c)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, importCheckConcurrency)
+	for _, c := range fresh {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(proxy *Proxy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p.checkNew(proxy)
+		}(c.proxy)
+	}
+	wg.Wait()
+
+	checked := make([]*Proxy, len(fresh))
+	for i, c := range fresh {
+		checked[i] = c.proxy
+	}
+	inserted := make(map[*Proxy]bool, len(checked))
+	for _, proxy := range p.insert(checked...) {
+		inserted[proxy] = true
+	}
+
+	var failed []*Proxy
+	for _, c := range fresh {
+		if !inserted[c.proxy] {
+			res.Duplicates = append(res.Duplicates, c.proxy.URL)
+			continue
+		}
+		if err := p.persistProxy(c.proxy); err != nil {
+			res.Errors = append(res.Errors, ImportError{Line: c.line, Input: c.proxy.URL, Reason: err.Error()})
+			failed = append(failed, c.proxy)
+			continue
+		}
+		res.Added = append(res.Added, c.proxy.URL)
+	}
+	if len(failed) > 0 {
+		p.withdraw(failed...)
+	}
+
+	log.Printf("[pool] %s: imported %d proxies (%d duplicates, %d errors)",
+		p.Name, len(res.Added), len(res.Duplicates), len(res.Errors))
+	return res, nil
+}
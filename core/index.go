@@ -0,0 +1,312 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// scoreIndex keeps the pool's proxies in a binary max-heap ordered the
+// way BestScore ranks them (score, then usage, then position in
+// Proxies), so the default allocation path finds the best eligible proxy
+// in O(log n) instead of scanning every proxy.
+//
+// The heap and the cached keys are guarded by the pool mutex. Score
+// changes happen under the proxy mutex only (health checks, reports), so
+// they are not applied directly: the proxy marks itself dirty under
+// dirtyMu, a leaf lock, and the next allocation re-reads the keys of the
+// dirty proxies and fixes their positions before looking at the top.
+type scoreIndex struct {
+	entries []*indexEntry
+	byProxy map[*Proxy]*indexEntry
+
+	dirtyMu sync.Mutex
+	dirty   map[*Proxy]struct{}
+}
+
+// poolIndex is the pool's score index: one scoreIndex per tier, so that
+// tiered pools are served tier by tier without scanning either.
+type poolIndex struct {
+	tiers map[int]*scoreIndex
+
+	// first and size identify the Proxies slice the index was built
+	// from; a different slice means membership changed and the index is
+	// rebuilt.
+	first *Proxy
+	size  int
+}
+
+func newPoolIndex(proxies []*Proxy) *poolIndex {
+	byTier := map[int][]*Proxy{}
+	for _, proxy := range proxies {
+		proxy.mu.Lock()
+		tier := proxy.Tier
+		proxy.mu.Unlock()
+		byTier[tier] = append(byTier[tier], proxy)
+	}
+
+	pi := &poolIndex{tiers: make(map[int]*scoreIndex, len(byTier)), size: len(proxies)}
+	if len(proxies) > 0 {
+		pi.first = proxies[0]
+	}
+	for tier, members := range byTier {
+		pi.tiers[tier] = newScoreIndex(members)
+	}
+	return pi
+}
+
+// matches reports whether the index was built from proxies.
+func (pi *poolIndex) matches(proxies []*Proxy) bool {
+	if pi.size != len(proxies) {
+		return false
+	}
+	return len(proxies) == 0 || pi.first == proxies[0]
+}
+
+// update sets proxy's key in the index of its tier. Must be called with
+// the pool mutex held.
+func (pi *poolIndex) update(proxy *Proxy, score float64, usage int) {
+	for _, idx := range pi.tiers {
+		if _, ok := idx.byProxy[proxy]; ok {
+			idx.update(proxy, score, usage)
+			return
+		}
+	}
+}
+
+type indexEntry struct {
+	proxy *Proxy
+	score float64
+	usage int
+	order int
+	pos   int
+}
+
+func newScoreIndex(proxies []*Proxy) *scoreIndex {
+	idx := &scoreIndex{
+		entries: make([]*indexEntry, len(proxies)),
+		byProxy: make(map[*Proxy]*indexEntry, len(proxies)),
+		dirty:   make(map[*Proxy]struct{}),
+	}
+
+	for i, proxy := range proxies {
+		proxy.mu.Lock()
+		e := &indexEntry{proxy: proxy, score: proxy.Score, usage: proxy.UsageCount, order: i, pos: i}
+		proxy.index = idx
+		proxy.mu.Unlock()
+
+		idx.entries[i] = e
+		idx.byProxy[proxy] = e
+	}
+	for i := len(idx.entries)/2 - 1; i >= 0; i-- {
+		idx.down(i)
+	}
+	return idx
+}
+
+// markDirty records that proxy's ranking key changed. Safe to call with
+// proxy.mu held.
+func (idx *scoreIndex) markDirty(proxy *Proxy) {
+	idx.dirtyMu.Lock()
+	idx.dirty[proxy] = struct{}{}
+	idx.dirtyMu.Unlock()
+}
+
+// refresh re-reads the keys of dirty proxies. Must be called with the
+// pool mutex held.
+func (idx *scoreIndex) refresh() {
+	idx.dirtyMu.Lock()
+	if len(idx.dirty) == 0 {
+		idx.dirtyMu.Unlock()
+		return
+	}
+	dirty := idx.dirty
+	idx.dirty = make(map[*Proxy]struct{})
+	idx.dirtyMu.Unlock()
+
+	for proxy := range dirty {
+		proxy.mu.Lock()
+		score, usage := proxy.Score, proxy.UsageCount
+		proxy.mu.Unlock()
+		idx.update(proxy, score, usage)
+	}
+}
+
+// update sets proxy's key and restores the heap order. Must be called
+// with the pool mutex held.
+func (idx *scoreIndex) update(proxy *Proxy, score float64, usage int) {
+	e, ok := idx.byProxy[proxy]
+	if !ok {
+		return
+	}
+	e.score, e.usage = score, usage
+	if !idx.up(e.pos) {
+		idx.down(e.pos)
+	}
+}
+
+// ascend calls fn with the proxies from best to worst until fn returns
+// false. Visiting the first k proxies costs O(k log k); the heap itself
+// is not modified.
+func (idx *scoreIndex) ascend(fn func(e *indexEntry) bool) {
+	if len(idx.entries) == 0 {
+		return
+	}
+
+	// frontier is a small heap of heap positions whose parents have
+	// already been visited.
+	frontier := []int{0}
+	less := func(a, b int) bool { return idx.less(frontier[a], frontier[b]) }
+
+	for len(frontier) > 0 {
+		top := frontier[0]
+		last := len(frontier) - 1
+		frontier[0] = frontier[last]
+		frontier = frontier[:last]
+		siftDown(len(frontier), 0, less, func(a, b int) { frontier[a], frontier[b] = frontier[b], frontier[a] })
+
+		if !fn(idx.entries[top]) {
+			return
+		}
+
+		for _, child := range []int{2*top + 1, 2*top + 2} {
+			if child < len(idx.entries) {
+				frontier = append(frontier, child)
+				siftUp(len(frontier)-1, less, func(a, b int) { frontier[a], frontier[b] = frontier[b], frontier[a] })
+			}
+		}
+	}
+}
+
+func (idx *scoreIndex) less(i, j int) bool {
+	a, b := idx.entries[i], idx.entries[j]
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if a.usage != b.usage {
+		return a.usage < b.usage
+	}
+	return a.order < b.order
+}
+
+func (idx *scoreIndex) swap(i, j int) {
+	idx.entries[i], idx.entries[j] = idx.entries[j], idx.entries[i]
+	idx.entries[i].pos = i
+	idx.entries[j].pos = j
+}
+
+func (idx *scoreIndex) up(i int) bool {
+	return siftUp(i, idx.less, idx.swap)
+}
+
+func (idx *scoreIndex) down(i int) {
+	siftDown(len(idx.entries), i, idx.less, idx.swap)
+}
+
+func siftUp(i int, less func(a, b int) bool, swap func(a, b int)) bool {
+	moved := false
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !less(i, parent) {
+			break
+		}
+		swap(i, parent)
+		i = parent
+		moved = true
+	}
+	return moved
+}
+
+func siftDown(n, i int, less func(a, b int) bool, swap func(a, b int)) {
+	for {
+		best := i
+		if l := 2*i + 1; l < n && less(l, best) {
+			best = l
+		}
+		if r := 2*i + 2; r < n && less(r, best) {
+			best = r
+		}
+		if best == i {
+			return
+		}
+		swap(i, best)
+		i = best
+	}
+}
+
+// indexable reports whether req can be served from the score index: the
+// pool ranks with BestScore and nothing in req changes the ranking.
+func (p *Pool) indexable(req *AllocationRequest) bool {
+	if _, ok := p.strategy().(BestScore); !ok {
+		return false
+	}
+	return req.Domain == "" && len(req.PreferTags) == 0
+}
+
+// scoreIndex returns the index of tier, (re)building the pool's index
+// when the Proxies slice changed and applying pending key changes. It
+// returns nil when no proxy is in tier. Must be called with p.mu held.
+func (p *Pool) scoreIndex(tier int) *scoreIndex {
+	if p.index == nil || !p.index.matches(p.Proxies) {
+		p.index = newPoolIndex(p.Proxies)
+	}
+	idx := p.index.tiers[tier]
+	if idx != nil {
+		idx.refresh()
+	}
+	return idx
+}
+
+// allocateIndexed returns the best proxy of tier that satisfies req and
+// can take traffic right now, or nil. It walks the tier's index from the
+// top and normally stops at the first entry; with diversity it skips
+// proxies in avoided subnets unless no other proxy is eligible. Must be
+// called with p.mu held.
+func (p *Pool) allocateIndexed(req *AllocationRequest, tier int, now time.Time) *Proxy {
+	idx := p.scoreIndex(tier)
+	if idx == nil {
+		return nil
+	}
+	var chosen, fallback *Proxy
+	diversify := p.diversifying(req)
+	idx.ascend(func(e *indexEntry) bool {
+		if req.MinScore != nil && e.score < *req.MinScore {
+			return false
+		}
+
+		proxy := e.proxy
+		proxy.mu.Lock()
+		proxy.age(now)
+		ok := req.matches(proxy) &&
+			!proxy.OnProbation &&
+			!proxy.expired(now) &&
+			proxy.admits(now) &&
+			!p.atCapacity(proxy) &&
+			p.throttled(proxy, now) == 0
+		avoided := ok && diversify && p.avoided(req, p.diversityKey(proxy), now)
+		proxy.mu.Unlock()
+
+		switch {
+		case avoided:
+			if fallback == nil {
+				fallback = proxy
+			}
+			return true
+		case ok:
+			chosen = proxy
+			return false
+		}
+		return true
+	})
+	if chosen == nil {
+		return fallback
+	}
+	return chosen
+}
+
+// scoreChanged tells the pool index that the proxy's Score or UsageCount
+// changed. Must be called with p.mu held.
+func (p *Proxy) scoreChanged() {
+	if p.index != nil {
+		p.index.markDirty(p)
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// scanBestScore ranks like BestScore but isn't one, which keeps the pool
+// on the scan path. Used to compare the two.
+type scanBestScore struct{ BestScore }
+
+func newIndexedPool(n int) *Pool {
+	proxies := make([]*Proxy, n)
+	for i := range proxies {
+		proxies[i] = &Proxy{
+			URL:      fmt.Sprintf("http://10.%d.%d.%d:8080", i>>16&0xff, i>>8&0xff, i&0xff),
+			Score:    float64(rand.IntN(15)) - 5,
+			LastTest: time.Now(),
+		}
+	}
+	return &Pool{Proxies: proxies}
+}
+
+func expectedBest(t *testing.T, pool *Pool, req *AllocationRequest) *Proxy {
+	t.Helper()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	saved := pool.Strategy
+	pool.Strategy = scanBestScore{}
+	defer func() { pool.Strategy = saved }()
+
+	proxy, err := pool.pick(req, time.Now())
+	if err != nil {
+		return nil
+	}
+	return proxy
+}
+
+func TestScoreIndex_MatchesScan(t *testing.T) {
+	pool := newIndexedPool(300)
+	min := 2.0
+
+	for i := range 3000 {
+		// Move scores around the way health checks and reports do.
+		p := pool.Proxies[rand.IntN(len(pool.Proxies))]
+		if rand.IntN(2) == 0 {
+			p.recordSuccess(100, sourceHealthCheck, 1)
+		} else {
+			p.recordFailure("test", nil, sourceHealthCheck, 1)
+		}
+
+		req := &AllocationRequest{}
+		if i%3 == 0 {
+			req.MinScore = &min
+		}
+		want := expectedBest(t, pool, req)
+
+		got, err := pool.allocate(false, req)
+		if want == nil {
+			if err == nil {
+				t.Fatalf("round %d: expected an error, got %s", i, got.URL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		if got != want {
+			t.Fatalf("round %d: index picked %s (%.2f), scan picked %s (%.2f)",
+				i, got.URL, got.Score, want.URL, want.Score)
+		}
+	}
+}
+
+func TestScoreIndex_MatchesScanWithTiers(t *testing.T) {
+	pool := newIndexedPool(300)
+	pool.Tiering = TierConfig{MinAlive: 60}
+	for i, p := range pool.Proxies {
+		p.Tier = i % 3
+	}
+
+	for i := range 3000 {
+		p := pool.Proxies[rand.IntN(len(pool.Proxies))]
+		switch rand.IntN(3) {
+		case 0:
+			p.recordSuccess(100, sourceHealthCheck, 1)
+		case 1:
+			p.recordFailure("test", nil, sourceHealthCheck, 1)
+		default:
+			// Trip and reset breakers so tiers go in and out of health.
+			if p.State == BreakerOpen {
+				p.State = BreakerClosed
+			} else {
+				tripBreaker(p)
+			}
+		}
+		if i%50 == 0 {
+			pool.UpdateTiers()
+		}
+
+		req := &AllocationRequest{}
+		want := expectedBest(t, pool, req)
+		got, err := pool.allocate(false, req)
+		if want == nil {
+			if err == nil {
+				t.Fatalf("round %d: expected an error, got %s", i, got.URL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		if got != want {
+			t.Fatalf("round %d: index picked %s (tier %d, %.2f), scan picked %s (tier %d, %.2f)",
+				i, got.URL, got.Tier, got.Score, want.URL, want.Tier, want.Score)
+		}
+	}
+}
+
+func TestScoreIndex_SkipsIneligibleTop(t *testing.T) {
+	pool := newIndexedPool(50)
+	for i, p := range pool.Proxies {
+		p.Score = float64(i) / 10
+	}
+	top := pool.Proxies[49]
+	second := pool.Proxies[48]
+	third := pool.Proxies[47]
+
+	tripBreaker(top)
+	second.MaxConcurrent = 1
+	second.ActiveLeases = 1
+
+	got, err := pool.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != third {
+		t.Fatalf("expected the best eligible proxy %s, got %s", third.URL, got.URL)
+	}
+}
+
+func TestScoreIndex_RebuildsOnMembershipChange(t *testing.T) {
+	pool := newIndexedPool(10)
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+
+	best := &Proxy{URL: "http://new:8080", Score: maxScore + 1, LastTest: time.Now()}
+	pool.mu.Lock()
+	pool.Proxies = append(append([]*Proxy(nil), pool.Proxies...), best)
+	pool.mu.Unlock()
+
+	got, err := pool.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != best {
+		t.Fatalf("expected the new proxy, got %s", got.URL)
+	}
+}
+
+func TestScoreIndex_SeesStickyUsage(t *testing.T) {
+	pool := newTestPool()
+	a, b := pool.Proxies[0], pool.Proxies[1]
+	a.UsageCount = 3
+
+	// Sticky hits bump b's usage outside the pool lock.
+	for range 5 {
+		proxy, _, err := pool.AllocateSession("s", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proxy != b {
+			t.Fatalf("expected the session on the less used proxy, got %s", proxy.URL)
+		}
+	}
+
+	got, err := pool.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != a {
+		t.Fatalf("expected the index to see the sticky usage and pick %s, got %s", a.URL, got.URL)
+	}
+}
+
+// benchmarkAllocate allocates from a pool of n proxies, backups of which
+// are in tier 1.
+func benchmarkAllocate(b *testing.B, n int, strategy AllocationStrategy, backups int) {
+	pool := newIndexedPool(n)
+	pool.Strategy = strategy
+	for i := range backups {
+		pool.Proxies[i].Tier = 1
+	}
+	if _, err := pool.Allocate(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := pool.Allocate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAllocate(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			benchmarkAllocate(b, n, nil, 0)
+		})
+		b.Run(fmt.Sprintf("scan/%d", n), func(b *testing.B) {
+			benchmarkAllocate(b, n, scanBestScore{}, 0)
+		})
+		b.Run(fmt.Sprintf("tiered/%d", n), func(b *testing.B) {
+			benchmarkAllocate(b, n, nil, 1)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	// latencyWindowSize is how many recent latencies, from checks and
+	// client reports, feed the quantiles, min, max and jitter.
+	latencyWindowSize = 100
+	// latencyAlpha is the EWMA smoothing factor: the weight of the newest
+	// sample.
+	latencyAlpha = 0.3
+
+	defaultLatencyReferenceMS = 1000
+)
+
+// LatencyStats summarizes a proxy's recent health check and reported
+// latencies, in milliseconds. Jitter is the mean absolute difference between
+// consecutive samples.
+type LatencyStats struct {
+	Samples int     `json:"samples"`
+	EWMA    float64 `json:"ewma_ms"`
+	P50     int     `json:"p50_ms"`
+	P90     int     `json:"p90_ms"`
+	P99     int     `json:"p99_ms"`
+	Min     int     `json:"min_ms"`
+	Max     int     `json:"max_ms"`
+	Jitter  float64 `json:"jitter_ms"`
+}
+
+// LatencyScoreConfig lets latency feed into the score: each success is
+// worth less the slower the proxy is, so of two proxies with the same
+// success rate the slower one settles at a lower score. A success at
+// ReferenceMS or slower gains (1 - Weight) of the usual amount. Weight
+// zero, the default, leaves the score latency-blind.
+type LatencyScoreConfig struct {
+	Weight      float64 `yaml:"weight"`
+	ReferenceMS int     `yaml:"reference_ms"`
+}
+
+// factor scales a success weight for a proxy with the given latency.
+func (c LatencyScoreConfig) factor(latencyMS float64) float64 {
+	if c.Weight <= 0 || latencyMS <= 0 {
+		return 1
+	}
+	ref := float64(c.ReferenceMS)
+	if ref <= 0 {
+		ref = defaultLatencyReferenceMS
+	}
+	return 1 - math.Min(c.Weight, 1)*math.Min(latencyMS/ref, 1)
+}
+
+// latencyWindow keeps an EWMA and a ring of the most recent samples.
+type latencyWindow struct {
+	ewma    float64
+	samples []int
+	next    int
+}
+
+func (w *latencyWindow) add(ms int) {
+	if len(w.samples) == 0 {
+		w.ewma = float64(ms)
+	} else {
+		w.ewma = latencyAlpha*float64(ms) + (1-latencyAlpha)*w.ewma
+	}
+
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, ms)
+		return
+	}
+	w.samples[w.next] = ms
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// ordered returns the samples oldest first.
+func (w *latencyWindow) ordered() []int {
+	out := make([]int, 0, len(w.samples))
+	out = append(out, w.samples[w.next:]...)
+	return append(out, w.samples[:w.next]...)
+}
+
+func (w *latencyWindow) stats() LatencyStats {
+	if w == nil || len(w.samples) == 0 {
+		return LatencyStats{}
+	}
+
+	seq := w.ordered()
+	var jitter float64
+	for i := 1; i < len(seq); i++ {
+		jitter += math.Abs(float64(seq[i] - seq[i-1]))
+	}
+	if len(seq) > 1 {
+		jitter /= float64(len(seq) - 1)
+	}
+
+	sorted := slices.Clone(seq)
+	slices.Sort(sorted)
+
+	return LatencyStats{
+		Samples: len(sorted),
+		EWMA:    w.ewma,
+		P50:     quantile(sorted, 0.50),
+		P90:     quantile(sorted, 0.90),
+		P99:     quantile(sorted, 0.99),
+		Min:     sorted[0],
+		Max:     sorted[len(sorted)-1],
+		Jitter:  jitter,
+	}
+}
+
+// quantile uses the nearest-rank method on sorted samples.
+func quantile(sorted []int, q float64) int {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// latencyEstimate is the latency allocation decisions use: the EWMA once
+// the proxy has been measured in this process, the last stored sample
+// before that. Zero means unknown. Must be called with p.mu held.
+func (p *Proxy) latencyEstimate() float64 {
+	if p.latency != nil && len(p.latency.samples) > 0 {
+		return p.latency.ewma
+	}
+	return float64(p.LatencyMS)
+}
+
+// LatencyStats returns the proxy's latency summary.
+func (p *Proxy) LatencyStats() LatencyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latency.stats()
+}
+
+// recordLatency must be called with p.mu held.
+func (p *Proxy) recordLatency(ms int) {
+	p.LatencyMS = ms
+	if p.latency == nil {
+		p.latency = &latencyWindow{}
+	}
+	p.latency.add(ms)
+}
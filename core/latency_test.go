@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestLatencyWindow_Stats(t *testing.T) {
+	var w latencyWindow
+	for i := 1; i <= 100; i++ {
+		w.add(i * 10)
+	}
+
+	st := w.stats()
+	if st.Samples != 100 || st.Min != 10 || st.Max != 1000 {
+		t.Fatalf("unexpected bounds: %+v", st)
+	}
+	if st.P50 != 500 || st.P90 != 900 || st.P99 != 990 {
+		t.Fatalf("unexpected quantiles: %+v", st)
+	}
+	if st.Jitter != 10 {
+		t.Fatalf("expected jitter 10, got %.2f", st.Jitter)
+	}
+	if st.EWMA < 900 || st.EWMA > 1000 {
+		t.Fatalf("expected EWMA close to the latest samples, got %.1f", st.EWMA)
+	}
+}
+
+func TestLatencyWindow_KeepsMostRecent(t *testing.T) {
+	var w latencyWindow
+	for range latencyWindowSize {
+		w.add(5000)
+	}
+	for range latencyWindowSize {
+		w.add(100)
+	}
+
+	st := w.stats()
+	if st.Max != 100 || st.Samples != latencyWindowSize {
+		t.Fatalf("old samples should have been evicted: %+v", st)
+	}
+	if st.Jitter != 0 {
+		t.Fatalf("expected no jitter for a flat window, got %.2f", st.Jitter)
+	}
+}
+
+func TestLatencyWindow_SingleOutlierBarelyMovesEWMA(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8888")
+	for range 20 {
+		p.recordSuccess(100, sourceHealthCheck, 1)
+	}
+	p.recordSuccess(3000, sourceHealthCheck, 1)
+	p.recordSuccess(100, sourceHealthCheck, 1)
+
+	pool := &Pool{Proxies: []*Proxy{p}}
+	_, err := pool.AllocateWith(context.Background(), AllocationRequest{MaxLatencyMS: 1000})
+	if err != nil {
+		t.Fatalf("one slow sample should not exclude the proxy: %v", err)
+	}
+	if p.LatencyMS != 100 {
+		t.Fatalf("LatencyMS should hold the last sample, got %d", p.LatencyMS)
+	}
+}
+
+func TestLatencyScore_SlowProxySettlesLower(t *testing.T) {
+	cfg := LatencyScoreConfig{Weight: 0.5, ReferenceMS: 1000}
+	fast := newTestProxy("http://127.0.0.1:8888")
+	slow := newTestProxy("http://127.0.0.1:8889")
+	fast.LatencyScore, slow.LatencyScore = cfg, cfg
+	fast.Score, slow.Score = 0, 0
+
+	for range 200 {
+		for range 2 {
+			fast.recordSuccess(50, sourceHealthCheck, 1)
+			slow.recordSuccess(1500, sourceHealthCheck, 1)
+		}
+		fast.recordFailure("test", nil, sourceHealthCheck, 1)
+		slow.recordFailure("test", nil, sourceHealthCheck, 1)
+	}
+
+	if slow.Score >= fast.Score {
+		t.Fatalf("expected the slow proxy to score lower: fast=%.2f slow=%.2f", fast.Score, slow.Score)
+	}
+}
+
+func TestLatencyScoreConfig_Factor(t *testing.T) {
+	cases := []struct {
+		cfg     LatencyScoreConfig
+		latency float64
+		want    float64
+	}{
+		{LatencyScoreConfig{}, 5000, 1},
+		{LatencyScoreConfig{Weight: 0.5}, 0, 1},
+		{LatencyScoreConfig{Weight: 0.5}, 500, 0.75},
+		{LatencyScoreConfig{Weight: 0.5, ReferenceMS: 200}, 5000, 0.5},
+		{LatencyScoreConfig{Weight: 2}, 1000, 0},
+	}
+	for _, c := range cases {
+		if got := c.cfg.factor(c.latency); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%+v.factor(%v) = %v, want %v", c.cfg, c.latency, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultLeaseTTL is used when neither the caller nor the pool config
+// asks for a specific lease duration.
+const DefaultLeaseTTL = 60 * time.Second
+
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// Lease marks a proxy as in use by a single caller until it is released
+// or until ExpiresAt passes, whichever comes first.
+type Lease struct {
+	ID         string
+	Proxy      *Proxy
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+
+	// index is the lease's position in Pool.deadlines.
+	index int
+}
+
+// leaseHeap is a min-heap of leases by ExpiresAt, for container/heap.
+type leaseHeap []*Lease
+
+func (h leaseHeap) Len() int           { return len(h) }
+func (h leaseHeap) Less(i, j int) bool { return h[i].ExpiresAt.Before(h[j].ExpiresAt) }
+
+func (h leaseHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *leaseHeap) Push(x any) {
+	lease := x.(*Lease)
+	lease.index = len(*h)
+	*h = append(*h, lease)
+}
+
+func (h *leaseHeap) Pop() any {
+	old := *h
+	lease := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return lease
+}
+
+// Acquire allocates a proxy carrying all of tags and holds it under a
+// lease for ttl.
+// A non-positive ttl falls back to the pool's LeaseTTL.
+//
+// Thread-safe.
+func (p *Pool) Acquire(ttl time.Duration, tags ...Tag) (*Lease, error) {
+	return p.AcquireWith(context.Background(), AllocationRequest{Tags: tags}, ttl)
+}
+
+// AcquireWith is Acquire with the full set of AllocationRequest criteria.
+// Leases are not sticky, so req.SessionKey is ignored.
+func (p *Pool) AcquireWith(ctx context.Context, req AllocationRequest, ttl time.Duration) (*Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.ExpireLeases()
+
+	if ttl <= 0 {
+		ttl = p.leaseTTL()
+	}
+
+	req.Domain = NormalizeDomain(req.Domain)
+	proxy, err := p.allocate(true, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	lease := &Lease{
+		ID:         uuid.New().String(),
+		Proxy:      proxy,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	p.leaseMu.Lock()
+	if p.leases == nil {
+		p.leases = make(map[string]*Lease)
+	}
+	p.leases[lease.ID] = lease
+	heap.Push(&p.deadlines, lease)
+	p.leaseMu.Unlock()
+
+	return lease, nil
+}
+
+// Release ends the lease with the given ID and frees its proxy slot.
+// Releasing an unknown or already expired lease returns ErrLeaseNotFound.
+func (p *Pool) Release(id string) error {
+	p.leaseMu.Lock()
+	lease, ok := p.leases[id]
+	if ok {
+		delete(p.leases, id)
+		heap.Remove(&p.deadlines, lease.index)
+	}
+	p.leaseMu.Unlock()
+
+	if !ok {
+		return ErrLeaseNotFound
+	}
+
+	lease.Proxy.releaseLease()
+	p.touch()
+	return nil
+}
+
+// ExpireLeases drops every lease past its expiry and returns how many
+// were reclaimed. Callers that never release their leases only hold a
+// proxy slot until the TTL runs out. It only looks at the leases it
+// reclaims, so it is cheap enough to run on every acquire.
+func (p *Pool) ExpireLeases() int {
+	now := time.Now()
+
+	p.leaseMu.Lock()
+	var expired []*Lease
+	for len(p.deadlines) > 0 && !now.Before(p.deadlines[0].ExpiresAt) {
+		lease := heap.Pop(&p.deadlines).(*Lease)
+		delete(p.leases, lease.ID)
+		expired = append(expired, lease)
+	}
+	p.leaseMu.Unlock()
+
+	for _, lease := range expired {
+		lease.Proxy.releaseLease()
+	}
+	if len(expired) > 0 {
+		p.touch()
+	}
+	return len(expired)
+}
+
+func (p *Pool) leaseTTL() time.Duration {
+	if p.LeaseTTL <= 0 {
+		return DefaultLeaseTTL
+	}
+	return p.LeaseTTL
+}
+
+func (p *Proxy) releaseLease() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ActiveLeases > 0 {
+		p.ActiveLeases--
+	}
+}
@@ -0,0 +1,434 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+)
+
+var (
+	ErrProxyExists  = errors.New("proxy already in pool")
+	ErrInvalidProxy = errors.New("invalid proxy")
+)
+
+// defaultCheckTimeout bounds the first health check of a proxy added at
+// runtime when the pool has no Timeout.
+const defaultCheckTimeout = 3 * time.Second
+
+// drainPollInterval is how often Remove looks at the active leases of a
+// proxy it is draining.
+const drainPollInterval = 100 * time.Millisecond
+
+// ProxyStore persists the proxies of one pool so runtime changes made
+// through Add, Update and Remove survive a restart.
+type ProxyStore interface {
+	SaveProxy(p *Proxy) error
+	DeleteProxy(proxyURL string) error
+	// RetireProxy keeps an expired proxy out of the active set without
+	// deleting it, see RetireExpired.
+	RetireProxy(proxyURL string) error
+	// RetiredProxy returns the stored state of a proxy retired by
+	// RetireProxy, or nil when proxyURL is not retired.
+	RetiredProxy(proxyURL string) (*Proxy, error)
+}
+
+// ProxyUpdate lists the settings Update changes. Nil fields are left
+// alone; an empty, non-nil Tags clears the tags and a zero ExpiresAt
+// clears the expiry.
+type ProxyUpdate struct {
+	MaxConcurrent *int
+	Tier          *int
+	ExpiresAt     *time.Time
+	RateLimit     *RateLimitConfig
+	Tags          map[string]string
+}
+
+// ProxyID returns the identifier the API uses for the proxy with the
+// given URL. It is derived from the URL, so it stays the same across
+// restarts and doesn't expose credentials embedded in the URL.
+func ProxyID(proxyURL string) string {
+	sum := sha256.Sum256([]byte(proxyURL))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ID returns ProxyID(p.URL), computed once.
+func (p *Proxy) ID() string {
+	p.idOnce.Do(func() { p.id = ProxyID(p.URL) })
+	return p.id
+}
+
+// Add health-checks a new proxy, makes it available for allocation and
+// persists it. The URL is normalized like an imported one: provider
+// shorthands get an http scheme, the scheme and host are lower-cased,
+// and only the schemes Import supports are accepted. Adding a URL the
+// pool already has, or one another Add or Import is adding, returns
+// ErrProxyExists.
+func (p *Pool) Add(pc ProxyConfig) (*Proxy, error) {
+	u, err := normalizeProxyURL(pc.URL, "http")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+	pc.URL = u
+	if err := pc.validate(); err != nil {
+		return nil, err
+	}
+	if len(p.reserve(pc.URL)) == 0 {
+		return nil, ErrProxyExists
+	}
+	defer p.unreserve(pc.URL)
+
+	proxy, err := p.newProxy(pc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxy, err)
+	}
+
+	p.revive(proxy)
+	p.checkNew(proxy)
+	if len(p.insert(proxy)) == 0 {
+		return nil, ErrProxyExists
+	}
+	if err := p.persistProxy(proxy); err != nil {
+		p.withdraw(proxy)
+		return nil, err
+	}
+
+	log.Printf("[pool] %s: added proxy %s", p.Name, proxy.URL)
+	return proxy, nil
+}
+
+// reserve claims the given URLs for an Add or Import while their proxies
+// are checked, and returns the ones it claimed: URLs already in the pool
+// or claimed by another call are left out. Release them with unreserve.
+func (p *Pool) reserve(urls ...string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	present := make(map[string]bool, len(p.Proxies))
+	for _, proxy := range p.Proxies {
+		present[proxy.URL] = true
+		// Config entries may not be written in canonical form.
+		if u, err := canonicalProxyURL(proxy.URL); err == nil {
+			present[u] = true
+		}
+	}
+	if p.adding == nil {
+		p.adding = make(map[string]bool)
+	}
+	claimed := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if present[u] || p.adding[u] {
+			continue
+		}
+		p.adding[u] = true
+		claimed = append(claimed, u)
+	}
+	return claimed
+}
+
+func (p *Pool) unreserve(urls ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, u := range urls {
+		delete(p.adding, u)
+	}
+}
+
+// withdraw takes proxies that were just inserted back out of the pool,
+// when persisting them failed. Leases handed out in between stay valid
+// until released.
+func (p *Pool) withdraw(proxies ...*Proxy) {
+	drop := make(map[*Proxy]bool, len(proxies))
+	for _, proxy := range proxies {
+		drop[proxy] = true
+	}
+
+	p.mu.Lock()
+	remaining := make([]*Proxy, 0, len(p.Proxies))
+	for _, proxy := range p.Proxies {
+		if !drop[proxy] {
+			remaining = append(remaining, proxy)
+		}
+	}
+	p.setProxies(remaining)
+	p.mu.Unlock()
+
+	for _, proxy := range proxies {
+		proxy.markRemoved()
+		p.dropSessions(proxy.URL)
+		proxy.Close()
+	}
+}
+
+// checkNew runs the first health check of a proxy that is not in the
+// pool yet and schedules its next one.
+func (p *Pool) checkNew(proxy *Proxy) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	proxy.Test(timeout)
+
+	proxy.mu.Lock()
+	p.scheduleNextCheck(proxy, time.Now())
+	proxy.mu.Unlock()
+}
+
+// insert appends the proxies whose URL is not in the pool yet and
+// returns them.
+func (p *Pool) insert(proxies ...*Proxy) []*Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	present := make(map[string]bool, len(p.Proxies))
+	for _, existing := range p.Proxies {
+		present[existing.URL] = true
+	}
+
+	merged := append(make([]*Proxy, 0, len(p.Proxies)+len(proxies)), p.Proxies...)
+	added := make([]*Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if present[proxy.URL] {
+			continue
+		}
+		present[proxy.URL] = true
+		merged = append(merged, proxy)
+		added = append(added, proxy)
+	}
+	if len(added) > 0 {
+		p.setProxies(merged)
+	}
+	return added
+}
+
+// Update changes the settings of the proxy with the given ID and
+// persists it. Runtime state (score, counters, breaker) is kept.
+func (p *Pool) Update(id string, u ProxyUpdate) (*Proxy, error) {
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	proxy := p.findID(id)
+	if proxy == nil {
+		return nil, ErrProxyNotFound
+	}
+
+	proxy.mu.Lock()
+	if u.MaxConcurrent != nil {
+		proxy.MaxConcurrent = *u.MaxConcurrent
+	}
+	if u.Tier != nil {
+		proxy.Tier = *u.Tier
+	}
+	if u.ExpiresAt != nil {
+		proxy.ExpiresAt = *u.ExpiresAt
+	}
+	if u.RateLimit != nil {
+		proxy.RateLimit = *u.RateLimit
+	}
+	if u.Tags != nil {
+		proxy.Tags = maps.Clone(u.Tags)
+	}
+	proxy.mu.Unlock()
+
+	if u.Tier != nil {
+		p.mu.Lock()
+		p.tierList = nil
+		p.index = nil
+		p.mu.Unlock()
+	}
+	p.touch()
+
+	if err := p.persistProxy(proxy); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// Remove takes the proxy with the given ID out of the pool and deletes
+// it from storage. It gets no new allocations and its sticky sessions
+// are dropped right away; Remove then waits for its active leases to be
+// released, until ctx is done, before closing its connections. It
+// returns how many leases were still active when it stopped waiting;
+// those stay valid until released or expired. When the store fails to
+// delete it, the proxy is put back in the pool and the error returned.
+func (p *Pool) Remove(ctx context.Context, id string) (int, error) {
+	p.mu.Lock()
+	var proxy *Proxy
+	remaining := make([]*Proxy, 0, len(p.Proxies))
+	for _, pr := range p.Proxies {
+		if proxy == nil && pr.ID() == id {
+			proxy = pr
+			continue
+		}
+		remaining = append(remaining, pr)
+	}
+	if proxy == nil {
+		p.mu.Unlock()
+		return 0, ErrProxyNotFound
+	}
+	p.setProxies(remaining)
+	p.mu.Unlock()
+
+	proxy.markRemoved()
+
+	// Delete before draining, so a restart during the drain doesn't load
+	// the proxy back from storage.
+	if p.ProxyStore != nil {
+		if err := p.ProxyStore.DeleteProxy(proxy.URL); err != nil {
+			proxy.unmarkRemoved()
+			if len(p.insert(proxy)) == 0 {
+				log.Printf("[warn] %s: proxy %s was re-added while its removal failed", p.Name, proxy.URL)
+			}
+			return 0, err
+		}
+	}
+
+	if n := p.dropSessions(proxy.URL); n > 0 {
+		log.Printf("[pool] %s: dropped %d sessions bound to %s", p.Name, n, proxy.URL)
+	}
+
+	active := proxy.drain(ctx)
+	proxy.Close()
+
+	log.Printf("[pool] %s: removed proxy %s (%d leases still active)", p.Name, proxy.URL, active)
+	return active, nil
+}
+
+// drain waits until the proxy has no active leases or ctx is done, and
+// returns the number still active.
+func (p *Proxy) drain(ctx context.Context) int {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		active := p.ActiveLeases
+		p.mu.Unlock()
+		if active == 0 {
+			return 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return active
+		}
+	}
+}
+
+// Removed reports whether the proxy has been taken out of its pool with
+// Remove. Stores must not save removed proxies.
+func (p *Proxy) Removed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removed
+}
+
+// Persist calls save with a snapshot of the proxy unless it has been
+// removed from its pool. Stores save through it: removal waits for a
+// running Persist, so a save that started before the proxy was removed
+// can't write it back after the store deleted or retired it.
+func (p *Proxy) Persist(save func(ProxySnapshot) error) error {
+	p.persistMu.Lock()
+	defer p.persistMu.Unlock()
+	snap := p.Snapshot()
+	if snap.Removed {
+		return nil
+	}
+	return save(snap)
+}
+
+// markRemoved flags the proxy as taken out of its pool once no Persist
+// is running.
+func (p *Proxy) markRemoved() {
+	p.persistMu.Lock()
+	defer p.persistMu.Unlock()
+	p.mu.Lock()
+	p.removed = true
+	p.mu.Unlock()
+}
+
+// unmarkRemoved undoes markRemoved for a proxy put back in its pool.
+func (p *Proxy) unmarkRemoved() {
+	p.persistMu.Lock()
+	defer p.persistMu.Unlock()
+	p.mu.Lock()
+	p.removed = false
+	p.mu.Unlock()
+}
+
+// dropSessions deletes every session bound to proxyURL.
+func (p *Pool) dropSessions(proxyURL string) int {
+	p.sessionMu.Lock()
+	var dropped []string
+	for key, s := range p.sessions {
+		if s.ProxyURL == proxyURL {
+			delete(p.sessions, key)
+			dropped = append(dropped, key)
+		}
+	}
+	p.sessionMu.Unlock()
+
+	for _, key := range dropped {
+		p.syncSession(key)
+	}
+	return len(dropped)
+}
+
+func (p *Pool) findID(id string) *Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proxy := range p.Proxies {
+		if proxy.ID() == id {
+			return proxy
+		}
+	}
+	return nil
+}
+
+func (p *Pool) persistProxy(proxy *Proxy) error {
+	if p.ProxyStore == nil {
+		return nil
+	}
+	return p.ProxyStore.SaveProxy(proxy)
+}
+
+// validate checks the settings of pc; Add normalizes the URL first.
+func (pc ProxyConfig) validate() error {
+	if pc.MaxConcurrent < 0 {
+		return fmt.Errorf("%w: max_concurrent must not be negative", ErrInvalidProxy)
+	}
+	if pc.Tier < 0 {
+		return fmt.Errorf("%w: tier must not be negative", ErrInvalidProxy)
+	}
+	if !pc.ExpiresAt.IsZero() && !pc.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at is in the past", ErrInvalidProxy)
+	}
+	return pc.RateLimit.validate()
+}
+
+func (u ProxyUpdate) validate() error {
+	if u.MaxConcurrent != nil && *u.MaxConcurrent < 0 {
+		return fmt.Errorf("%w: max_concurrent must not be negative", ErrInvalidProxy)
+	}
+	if u.Tier != nil && *u.Tier < 0 {
+		return fmt.Errorf("%w: tier must not be negative", ErrInvalidProxy)
+	}
+	if u.ExpiresAt != nil && !u.ExpiresAt.IsZero() && !u.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at is in the past", ErrInvalidProxy)
+	}
+	if u.RateLimit != nil {
+		return u.RateLimit.validate()
+	}
+	return nil
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.Requests < 0 || c.IntervalSeconds < 0 || c.Burst < 0 {
+		return fmt.Errorf("%w: rate_limit values must not be negative", ErrInvalidProxy)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import "time"
+
+// PoolCounts is a census of the pool's proxies by breaker state. Alive
+// leaves out proxies on probation, which only get a trial share of
+// allocations.
+type PoolCounts struct {
+	Total    int `json:"total"`
+	Alive    int `json:"alive"`
+	Closed   int `json:"closed"`
+	HalfOpen int `json:"half_open"`
+	Open     int `json:"open"`
+	// Probation counts proxies on probation, whatever their state.
+	Probation int `json:"probation"`
+	// Expiring counts proxies within the pool's ExpiryWarning of their
+	// ExpiresAt.
+	Expiring int `json:"expiring"`
+}
+
+// All returns the current members of the pool. The slice is a copy;
+// the proxies are live and must only be read through their methods.
+func (p *Pool) All() []*Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxies := make([]*Proxy, len(p.Proxies))
+	copy(proxies, p.Proxies)
+	return proxies
+}
+
+// ForEach calls fn for every member of the pool. It iterates over a copy
+// of the membership without holding the pool lock, so fn may call back
+// into the pool.
+func (p *Pool) ForEach(fn func(*Proxy)) {
+	for _, proxy := range p.All() {
+		fn(proxy)
+	}
+}
+
+// Counts returns the live census of the pool. Unlike Snapshot it takes
+// the pool and proxy locks, so it is always current.
+func (p *Pool) Counts() PoolCounts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	c := PoolCounts{Total: len(p.Proxies)}
+	for _, proxy := range p.Proxies {
+		proxy.mu.Lock()
+		if proxy.alive(now) && !proxy.OnProbation {
+			c.Alive++
+		}
+		if proxy.OnProbation {
+			c.Probation++
+		}
+		if p.expiring(proxy, now) {
+			c.Expiring++
+		}
+		switch proxy.State {
+		case BreakerClosed:
+			c.Closed++
+		case BreakerHalfOpen:
+			c.HalfOpen++
+		case BreakerOpen:
+			c.Open++
+		}
+		proxy.mu.Unlock()
+	}
+	return c
+}
+
+// Replace swaps the pool's membership for proxies. Outstanding leases
+// on dropped proxies stay valid until released, and sessions bound to
+// them fail over on their next use.
+func (p *Pool) Replace(proxies []*Proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setProxies(append([]*Proxy(nil), proxies...))
+}
+
+// setProxies must be called with p.mu held.
+func (p *Pool) setProxies(proxies []*Proxy) {
+	p.Proxies = proxies
+	p.index = nil
+	p.tierList = nil
+	p.probation = nil
+	p.touch()
+}
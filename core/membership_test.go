@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newCheckedPool builds a pool of n proxies whose health checks succeed
+// against a local server acting as the forward proxy.
+func newCheckedPool(t *testing.T, n int) *Pool {
+	t.Helper()
+	return newCheckedPoolWith(t, n, PoolConfig{})
+}
+
+// newCheckedPoolWith is newCheckedPool with the rest of cfg applied.
+func newCheckedPoolWith(t *testing.T, n int, cfg PoolConfig) *Pool {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cfg.HealthCheckURL = "http://check.invalid/"
+	cfg.TimeoutSeconds = 2
+	for i := range n {
+		cfg.Proxies = append(cfg.Proxies, ProxyConfig{URL: fmt.Sprintf("http://user%d@%s", i, host)})
+	}
+	pool, err := NewPool("race", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.CheckInterval = time.Millisecond
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestCounts(t *testing.T) {
+	pool := newTestPool()
+	pool.Proxies = append(pool.Proxies, newTestProxy("http://127.0.0.1:8890"))
+	tripBreaker(pool.Proxies[0])
+	pool.Proxies[1].State = BreakerHalfOpen
+
+	c := pool.Counts()
+	want := PoolCounts{Total: 3, Alive: 2, Closed: 1, HalfOpen: 1, Open: 1}
+	if c != want {
+		t.Fatalf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestReplace_SwapsMembership(t *testing.T) {
+	pool := newTestPool()
+	old := pool.All()
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	lease, err := pool.Acquire(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := []*Proxy{newTestProxy("http://10.0.0.9:8080")}
+	pool.Replace(fresh)
+	fresh[0] = nil // Replace must have copied the slice.
+
+	all := pool.All()
+	if len(all) != 1 || all[0].URL != "http://10.0.0.9:8080" {
+		t.Fatalf("unexpected membership after Replace: %v", all)
+	}
+	for range 3 {
+		p, err := pool.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != all[0] {
+			t.Fatalf("allocated a dropped proxy %s", p.URL)
+		}
+	}
+	if snap := pool.Publish(); len(snap.Stats) != 1 || snap.Stats[0].URL != all[0].URL {
+		t.Fatalf("snapshot still shows the old membership: %+v", snap.Stats)
+	}
+
+	// Leases taken before the swap can still be released.
+	if err := pool.Release(lease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if old[0].ActiveLeases+old[1].ActiveLeases != 0 {
+		t.Fatal("lease on a dropped proxy was not released")
+	}
+}
+
+// TestPool_ConcurrentUse exercises every entry point at once; run it with
+// -race to check the locking.
+func TestPool_ConcurrentUse(t *testing.T) {
+	pool := newCheckedPool(t, 8)
+	members := pool.All()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	// Allocation, leases and sessions.
+	for range 4 {
+		run(func(i int) {
+			_, _ = pool.Allocate()
+			if lease, err := pool.AcquireWith(ctx, AllocationRequest{Domain: "example.com"}, time.Minute); err == nil {
+				_ = pool.ReleaseWithOutcome(lease.ID, Outcome{Success: i%3 != 0, Domain: "example.com"})
+			}
+			_, _ = pool.AllocateWith(ctx, AllocationRequest{SessionKey: fmt.Sprintf("s%d", i%5)})
+		})
+	}
+	// Health checks.
+	run(func(int) { pool.HealthCheck(time.Second) })
+	// Persistence, the way health.Manager does it.
+	run(func(int) {
+		pool.ForEach(func(p *Proxy) {
+			_ = p.Snapshot()
+			_ = p.Domains()
+			_ = p.LatencyStats()
+		})
+	})
+	// Readers.
+	run(func(int) {
+		_ = pool.Counts()
+		_ = pool.Snapshots()
+		_ = pool.AliveProxies()
+		_ = pool.Sessions()
+		_ = pool.ExpireLeases()
+		_ = pool.ExpireSessions()
+	})
+	// Client reports.
+	run(func(i int) {
+		_ = pool.Report(members[i%len(members)].URL, Outcome{Success: i%2 == 0, ErrorClass: ErrorClassTimeout})
+	})
+	// Membership changes.
+	run(func(i int) {
+		if i%2 == 0 {
+			pool.Replace(members[:len(members)/2])
+		} else {
+			pool.Replace(members)
+		}
+		time.Sleep(time.Millisecond)
+	})
+
+	wg.Wait()
+
+	pool.Replace(members)
+	total := 0
+	for _, p := range pool.All() {
+		p.mu.Lock()
+		total += p.ActiveLeases
+		p.mu.Unlock()
+	}
+	if total != 0 {
+		t.Fatalf("expected every lease to be released, %d still active", total)
+	}
+}
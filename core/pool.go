@@ -1,191 +1,413 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
-	"sort"
 	"sync"
 	"time"
-
-	"os"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Pooler defines the behavior of a proxy pool.
-// It allows selecting (allocating) a proxy, running health checks,
-// returning alive proxies, and obtaining read-only snapshots.
+// It allows selecting (allocating), leasing or pinning (sticky sessions)
+// a proxy, running health checks, returning alive proxies, and obtaining
+// read-only snapshots. The snapshot readers never block allocation.
+// Callers that need only part of it take one of the smaller interfaces
+// it is made of.
 type Pooler interface {
-	Allocate() (*Proxy, error)
-	HealthCheck(timeout time.Duration)
+	Allocator
+	SessionManager
+	Manager
+	Maintainer
+	All() []*Proxy
+	Replace(proxies []*Proxy)
 	AliveProxies() []*Proxy
+}
+
+// Allocator hands out proxies, directly or through leases, and takes
+// reports on how they behaved.
+type Allocator interface {
+	Allocate(tags ...Tag) (*Proxy, error)
+	AllocateWith(ctx context.Context, req AllocationRequest) (*Allocation, error)
+	AllocateBatch(ctx context.Context, req AllocationRequest, n int) ([]*Proxy, error)
+	AcquireWith(ctx context.Context, req AllocationRequest, ttl time.Duration) (*Lease, error)
+	Release(id string) error
+	ReleaseWithOutcome(id string, o Outcome) error
+	Report(proxyURL string, o Outcome) error
+}
+
+// SessionManager lists and ends sticky sessions.
+type SessionManager interface {
+	Sessions() []Session
+	DeleteSession(key string) error
+}
+
+// Manager changes a pool's membership at runtime.
+type Manager interface {
+	Add(pc ProxyConfig) (*Proxy, error)
+	Import(data []byte, opts ImportOptions) (ImportResult, error)
+	Update(id string, u ProxyUpdate) (*Proxy, error)
+	Remove(ctx context.Context, id string) (int, error)
+	Stats(proxy *Proxy) ProxyStats
+}
+
+// Reporter gives read-only views of a pool.
+type Reporter interface {
+	Snapshot() *PoolSnapshot
 	Snapshots() []ProxyStats
+	SubnetStats() []SubnetStats
+	Counts() PoolCounts
+	Tiers() []TierStatus
+	TierEvents() []TierEvent
+	DomainScores(proxyURL string) ([]DomainScore, error)
 }
 
+// Maintainer is what the background health loop runs against a pool.
+type Maintainer interface {
+	Reporter
+	HealthCheck(timeout time.Duration)
+	ExpireLeases() int
+	ExpireSessions() int
+	UpdateTiers() []TierEvent
+	RetireExpired() []*Proxy
+	ForEach(fn func(*Proxy))
+}
+
+var (
+	ErrNoAliveProxies = errors.New("no alive proxies")
+	ErrAtCapacity     = errors.New("all alive proxies are at their concurrency limit")
+)
+
 type Pool struct {
+	// Name identifies the pool in config, storage and the /pools/{name}
+	// API routes.
+	Name string
+	// Proxies is the pool's membership. Set it when building the pool;
+	// once the pool is in use go through All, ForEach and Replace.
 	Proxies []*Proxy
-	mu      sync.Mutex
+	// CheckURL and Timeout are handed to every proxy for health checks.
+	CheckURL string
+	Timeout  time.Duration
+	// Strategy picks among alive proxies on Allocate.
+	// A nil Strategy behaves like BestScore.
+	Strategy AllocationStrategy
+	// LeaseTTL is the lease duration used when Acquire is called
+	// without one. Zero means DefaultLeaseTTL.
+	LeaseTTL time.Duration
+	// Feedback weighs client-reported outcomes against health checks.
+	Feedback FeedbackConfig
+	// SessionStore, when set, persists sticky session bindings.
+	SessionStore SessionStore
+	// ProxyStore, when set, persists proxies added, updated or removed
+	// at runtime.
+	ProxyStore ProxyStore
+	// MaxConcurrent caps active leases per proxy for proxies that don't
+	// set their own limit. Zero means unlimited.
+	MaxConcurrent int
+	// RateLimit is the default per-proxy request budget for proxies that
+	// don't set their own.
+	RateLimit RateLimitConfig
+	// Breaker is the circuit breaker config handed to every proxy.
+	Breaker BreakerConfig
+	// LatencyScore is handed to every proxy, see LatencyScoreConfig.
+	LatencyScore LatencyScoreConfig
+	// Scoring selects the scoring model of every proxy.
+	Scoring ScoringConfig
+	// CheckInterval is how often HealthCheck runs; Backoff stretches it
+	// for proxies that keep failing.
+	CheckInterval time.Duration
+	Backoff       BackoffConfig
+	// Probation is handed to every new proxy, see ProbationConfig.
+	Probation ProbationConfig
+	// Tiering sets when a proxy tier counts as degraded.
+	Tiering TierConfig
+	// Diversity spreads allocations across subnets.
+	Diversity DiversityConfig
+	// ExpiryWarning is how long before their ExpiresAt proxies count as
+	// expiring. Zero means 72 hours.
+	ExpiryWarning time.Duration
+	mu            sync.Mutex
+	// index speeds up best-score allocation, see poolIndex.
+	index *poolIndex
+	// snapshots publishes the read-only view behind Snapshot.
+	snapshots snapshotState
+	// tiers is the last tier evaluation. tierList caches the distinct
+	// tiers of Proxies; nil means it needs rebuilding.
+	tiers    tierState
+	tierList []int
+	// agedAt is the last time ageAll aged every proxy.
+	agedAt time.Time
+	// diversity tracks recently allocated subnets for Diversity.
+	diversity diversityState
+	// probation lists the proxies on probation; nil means it needs
+	// rebuilding, see probationMembers.
+	probation []*Proxy
+	// adding holds the URLs Add and Import are checking, see reserve.
+	adding map[string]bool
+
+	leases map[string]*Lease
+	// deadlines orders leases by expiry, so ExpireLeases only visits the
+	// ones it reclaims.
+	deadlines leaseHeap
+	leaseMu   sync.Mutex
+
+	sessions  map[string]*Session
+	sessionMu sync.Mutex
+	// sessionSyncMu serializes SessionStore writes, see syncSession.
+	sessionSyncMu sync.Mutex
 }
 
-type Config struct {
-	HealthCheckURL string   `yaml:"health_check_url"`
-	TimeoutSeconds int      `yaml:"timeout_seconds"`
-	Proxies        []string `yaml:"proxies"`
+type ProxyStats struct {
+	ID              string            `json:"id"`
+	URL             string            `json:"url"`
+	IP              string            `json:"ip,omitempty"`
+	Subnet          string            `json:"subnet"`
+	ASN             string            `json:"asn,omitempty"`
+	Alive           bool              `json:"alive"`
+	State           string            `json:"state"`
+	StateChanged    string            `json:"state_changed_at"`
+	LastTest        string            `json:"last_test"`
+	NextCheck       string            `json:"next_check"`
+	Score           float64           `json:"score"`
+	UsageCount      int               `json:"usage_count"`
+	ActiveLeases    int               `json:"active_leases"`
+	MaxConcurrent   int               `json:"max_concurrent"`
+	Tier            int               `json:"tier"`
+	ExpiresAt       string            `json:"expires_at,omitempty"`
+	Expiring        bool              `json:"expiring"`
+	Probation       bool              `json:"probation"`
+	ProbationPasses int               `json:"probation_passes"`
+	FailCount       int               `json:"fail_count"`
+	SuccessCount    int               `json:"success_count"`
+	LatencyMS       int               `json:"latency_ms"`
+	Latency         LatencyStats      `json:"latency"`
+	Tags            map[string]string `json:"tags"`
 }
 
-type ProxyStats struct {
-	URL          string  `json:"url"`
-	Alive        bool    `json:"alive"`
-	LastTest     string  `json:"last_test"`
-	Score        float64 `json:"score"`
-	UsageCount   int     `json:"usage_count"`
-	FailCount    int     `json:"fail_count"`
-	SuccessCount int     `json:"success_count"`
-	LatencyMS    int     `json:"latency_ms"`
-}
-
-func LoadConfig(path string) (*Pool, error) {
-	data, err := os.ReadFile(path)
+// Allocate selects an alive proxy carrying all of tags using the pool's
+// Strategy (best-score when unset) and bumps its UsageCount.
+// Proxies whose active leases have reached their concurrency cap, or
+// that have used up their rate limit budget, are skipped.
+// Use AllocateWith for richer selection criteria.
+//
+// Thread-safe.
+func (p *Pool) Allocate(tags ...Tag) (*Proxy, error) {
+	return p.allocate(false, &AllocationRequest{Tags: tags})
+}
+
+// allocate picks a proxy matching req and, when lease is set, takes one
+// of its concurrency slots while still holding the pool lock so two
+// callers can never both squeeze into the last slot.
+func (p *Pool) allocate(lease bool, req *AllocationRequest) (*Proxy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	chosen, err := p.pick(req, now)
 	if err != nil {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	chosen.mu.Lock()
+	chosen.UsageCount++
+	chosen.admit()
+	p.consumeToken(chosen, now)
+	p.noteAllocated(chosen, now)
+	if lease {
+		chosen.ActiveLeases++
 	}
+	score, usage := chosen.Score, chosen.UsageCount
+	chosen.mu.Unlock()
 
-	proxies := make([]*Proxy, 0, len(cfg.Proxies))
-	for _, u := range cfg.Proxies {
-		proxyURL, err := url.Parse(u)
-		if err != nil {
-			log.Printf("Skipping invalid proxy URL %s: %v", u, err)
-			continue
-		}
+	if p.index != nil {
+		p.index.update(chosen, score, usage)
+	}
+	p.touch()
+	return chosen, nil
+}
 
-		transport := &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
+// pick chooses the proxy for req: now and then one on probation,
+// otherwise one from the first tier in tierOrder that has an eligible
+// proxy. Must be called with p.mu held.
+func (p *Pool) pick(req *AllocationRequest, now time.Time) (*Proxy, error) {
+	if p.probationTrial() {
+		if members := p.probationMembers(); len(members) > 0 {
+			scope := scanScope{probation: true, tier: anyTier, members: members}
+			if chosen, err := p.scan(req, now, scope); err == nil {
+				return chosen, nil
+			}
 		}
-
-		proxies = append(proxies, &Proxy{
-			URL:          u,
-			Alive:        true,
-			LastTest:     time.Now(),
-			CheckURL:     cfg.HealthCheckURL,
-			Timeout:      time.Duration(cfg.TimeoutSeconds) * time.Second,
-			UsageCount:   0,
-			FailCount:    0,
-			SuccessCount: 0,
-			Score:        6,
-			transport:    transport,
-			client: &http.Client{
-				Timeout:   time.Duration(cfg.TimeoutSeconds) * time.Second,
-				Transport: transport,
-			},
-		})
 	}
 
-	return &Pool{Proxies: proxies}, nil
+	tiers := p.tierOrder()
+	if p.indexable(req) {
+		p.ageAll(now)
+		indexed := tiers
+		if indexed == nil {
+			// A single tier, or none when the pool is empty.
+			indexed = p.tierList
+		}
+		for _, tier := range indexed {
+			if chosen := p.allocateIndexed(req, tier, now); chosen != nil {
+				return chosen, nil
+			}
+		}
+	} else {
+		for _, tier := range tiers {
+			if chosen, err := p.scan(req, now, scanScope{tier: tier}); err == nil {
+				return chosen, nil
+			}
+		}
+	}
+	// A scan across every tier also tells why nothing was eligible.
+	return p.scan(req, now, scanScope{tier: anyTier})
 }
 
-// Allocate selects the best available proxy.
-// Selection rules:
-//  1. Only Alive proxies are considered
-//  2. Highest Score wins
-//  3. On score tie, proxy with lower UsageCount is preferred
-//
-// Thread-safe.
-func (p *Pool) Allocate() (*Proxy, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// scanScope limits a scan to proxies on or off probation, and to one
+// tier unless tier is anyTier. members, when set, replaces Proxies as
+// the proxies to look at.
+type scanScope struct {
+	probation bool
+	tier      int
+	members   []*Proxy
+}
 
-	type candidate struct {
-		proxy *Proxy
-		score float64
-		use   int
+// scan looks at the proxies in scope and lets the strategy pick among
+// the eligible ones. When there are none, the error tells why.
+// Must be called with p.mu held.
+func (p *Pool) scan(req *AllocationRequest, now time.Time, scope scanScope) (*Proxy, error) {
+	members := scope.members
+	if members == nil {
+		members = p.Proxies
 	}
-
-	candidates := make([]candidate, 0, len(p.Proxies))
-
-	for _, proxy := range p.Proxies {
+	candidates := make([]Candidate, 0, len(members))
+	preferred := make([]bool, 0, len(members))
+	matched, alive := 0, 0
+	throttled := false
+	var retryAfter time.Duration
+
+	inScope := 0
+	for _, proxy := range members {
 		proxy.mu.Lock()
-		if proxy.Alive {
-			candidates = append(candidates, candidate{
-				proxy: proxy,
-				score: proxy.Score,
-				use:   proxy.UsageCount,
-			})
+		proxy.age(now)
+		if scope.tier != anyTier && proxy.Tier != scope.tier {
+			proxy.mu.Unlock()
+			continue
+		}
+		inScope++
+		if !req.matches(proxy) {
+			proxy.mu.Unlock()
+			continue
+		}
+		matched++
+		if proxy.OnProbation != scope.probation || proxy.expired(now) || !proxy.admits(now) {
+			proxy.mu.Unlock()
+			continue
+		}
+		alive++
+		if p.atCapacity(proxy) {
+			proxy.mu.Unlock()
+			continue
 		}
+		if wait := p.throttled(proxy, now); wait > 0 {
+			if !throttled || wait < retryAfter {
+				retryAfter = wait
+			}
+			throttled = true
+			proxy.mu.Unlock()
+			continue
+		}
+		successes, failures := proxy.banditCounts()
+		candidates = append(candidates, Candidate{
+			Proxy:        proxy,
+			Score:        proxy.scoreFor(req.Domain),
+			UsageCount:   proxy.UsageCount,
+			InUse:        proxy.ActiveLeases,
+			LatencyMS:    int(math.Round(proxy.latencyEstimate())),
+			SuccessCount: successes,
+			FailCount:    failures,
+		})
+		preferred = append(preferred, proxy.hasTags(req.PreferTags))
 		proxy.mu.Unlock()
 	}
 
 	if len(candidates) == 0 {
-		return nil, errors.New("no alive proxies")
+		switch {
+		case inScope > 0 && matched == 0:
+			return nil, ErrNoMatch
+		case alive == 0:
+			return nil, ErrNoAliveProxies
+		case throttled:
+			return nil, &RateLimitError{RetryAfter: retryAfter}
+		default:
+			return nil, ErrAtCapacity
+		}
 	}
 
-	sort.SliceStable(candidates, func(i, j int) bool {
-		if candidates[i].score == candidates[j].score {
-			return candidates[i].use < candidates[j].use
-		}
-		return candidates[i].score > candidates[j].score
-	})
+	candidates = req.narrowToPreferred(candidates, preferred)
+	candidates = p.narrowToDiverse(req, candidates, now)
+	return candidates[p.strategy().Select(candidates)].Proxy, nil
+}
 
-	chosen := candidates[0].proxy
+// maxConcurrent resolves the concurrency cap for proxy, falling back to
+// the pool-wide default. Must be called with proxy.mu held.
+func (p *Pool) maxConcurrent(proxy *Proxy) int {
+	if proxy.MaxConcurrent > 0 {
+		return proxy.MaxConcurrent
+	}
+	return p.MaxConcurrent
+}
 
-	chosen.mu.Lock()
-	chosen.UsageCount++
-	chosen.mu.Unlock()
+// atCapacity must be called with proxy.mu held.
+func (p *Pool) atCapacity(proxy *Proxy) bool {
+	limit := p.maxConcurrent(proxy)
+	return limit > 0 && proxy.ActiveLeases >= limit
+}
 
-	return chosen, nil
+func (p *Pool) strategy() AllocationStrategy {
+	if p.Strategy == nil {
+		return BestScore{}
+	}
+	return p.Strategy
 }
 
-// HealthCheck performs a concurrent check of all proxies using Proxy.Test().
-// It applies score decay, logs status transitions (recovered/degraded),
-// and updates latency, score, alive state, etc.
+// HealthCheck performs a concurrent check of the proxies that are due,
+// using Proxy.Test(). It applies score decay and feeds each result into
+// the proxy's circuit breaker, which logs its own state transitions.
+// Failing proxies are re-checked with exponential backoff.
 func (p *Pool) HealthCheck(timeout time.Duration) {
-	p.mu.Lock()
-	proxies := make([]*Proxy, len(p.Proxies))
-	copy(proxies, p.Proxies)
-	p.mu.Unlock()
+	proxies := p.All()
+	start := time.Now()
 
 	var wg sync.WaitGroup
 	for _, proxy := range proxies {
+		if !p.dueForCheck(proxy, start) {
+			continue
+		}
+
 		wg.Add(1)
 		go func(pr *Proxy) {
 			defer wg.Done()
 
-			prevAlive := pr.Snapshot().Alive
 			pr.Test(timeout)
 
 			pr.mu.Lock()
-			status := pr.Alive
+			p.scheduleNextCheck(pr, start)
+			state := pr.State
 			score := pr.Score
+			next := pr.NextCheck
 			pr.mu.Unlock()
 
-			if status && !prevAlive {
-				log.Printf("Proxy recovered: %s (score=%.1f)", pr.URL, score)
-			} else if !status && prevAlive {
-				log.Printf("Proxy degraded: %s (score=%.1f)", pr.URL, score)
-			} else {
-				log.Printf("Proxy check: %s (alive=%t, score=%.1f)", pr.URL, status, score)
-			}
+			log.Printf("Proxy check: %s (state=%s, score=%.1f, next=%s)",
+				pr.URL, state, score, next.Format(time.TimeOnly))
 		}(proxy)
 	}
 	wg.Wait()
-}
 
-func (p *Pool) AliveProxies() []*Proxy {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	alive := []*Proxy{}
-	for _, proxy := range p.Proxies {
-		if proxy.Alive {
-			alive = append(alive, proxy)
-		}
-	}
-	return alive
+	p.Publish()
 }
 
 func (p *Pool) Close() {
@@ -210,25 +432,3 @@ func (p *Proxy) RebuildHTTPClient() error {
 	}
 	return nil
 }
-
-func (p *Pool) Snapshots() []ProxyStats {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	stats := make([]ProxyStats, len(p.Proxies))
-	for i, pr := range p.Proxies {
-		pr.mu.Lock()
-		stats[i] = ProxyStats{
-			URL:          pr.URL,
-			Alive:        pr.Alive,
-			LastTest:     pr.LastTest.Format(time.RFC3339),
-			Score:        pr.Score,
-			UsageCount:   pr.UsageCount,
-			FailCount:    pr.FailCount,
-			SuccessCount: pr.SuccessCount,
-			LatencyMS:    pr.LatencyMS,
-		}
-		pr.mu.Unlock()
-	}
-	return stats
-}
@@ -1,7 +1,11 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -20,7 +24,6 @@ func newTestPool() *Pool {
 func newTestProxy(url string) *Proxy {
 	return &Proxy{
 		URL:       url,
-		Alive:     true,
 		Score:     6,
 		LastTest:  time.Now(),
 		Timeout:   2 * time.Second,
@@ -29,11 +32,16 @@ func newTestProxy(url string) *Proxy {
 	}
 }
 
+func tripBreaker(p *Proxy) {
+	p.State = BreakerOpen
+	p.StateChangedAt = time.Now()
+}
+
 func TestAllocate_PrefersHigherScore(t *testing.T) {
 	pool := &Pool{
 		Proxies: []*Proxy{
-			{URL: "http://127.0.0.1:8888", Alive: true, Score: 10},
-			{URL: "http://127.0.0.1:8889", Alive: true, Score: 5},
+			{URL: "http://127.0.0.1:8888", Score: 10},
+			{URL: "http://127.0.0.1:8889", Score: 5},
 		},
 	}
 
@@ -59,8 +67,8 @@ func TestAllocate_PrefersHigherScore(t *testing.T) {
 
 func TestAllocate_NoAliveProxies(t *testing.T) {
 	pool := newTestPool()
-	pool.Proxies[0].Alive = false
-	pool.Proxies[1].Alive = false
+	tripBreaker(pool.Proxies[0])
+	tripBreaker(pool.Proxies[1])
 
 	_, err := pool.Allocate()
 	if err == nil {
@@ -71,8 +79,8 @@ func TestAllocate_NoAliveProxies(t *testing.T) {
 func TestUsageCountIncrements(t *testing.T) {
 	pool := &Pool{
 		Proxies: []*Proxy{
-			{URL: "http://127.0.0.1:8888", Alive: true, Score: 10},
-			{URL: "http://127.0.0.1:8889", Alive: true, Score: 1},
+			{URL: "http://127.0.0.1:8888", Score: 10},
+			{URL: "http://127.0.0.1:8889", Score: 1},
 		},
 	}
 
@@ -119,8 +127,8 @@ func TestConcurrentAllocation_Safe(t *testing.T) {
 }
 
 func TestAllocate_PrefersLowerUsageOnTie(t *testing.T) {
-	p1 := &Proxy{URL: "A", Alive: true, Score: 5, UsageCount: 0}
-	p2 := &Proxy{URL: "B", Alive: true, Score: 5, UsageCount: 10}
+	p1 := &Proxy{URL: "A", Score: 5, UsageCount: 0}
+	p2 := &Proxy{URL: "B", Score: 5, UsageCount: 10}
 
 	pool := &Pool{Proxies: []*Proxy{p1, p2}}
 
@@ -139,8 +147,8 @@ func TestAllocate_PrefersLowerUsageOnTie(t *testing.T) {
 }
 
 func TestAllocate_IgnoresDeadProxies(t *testing.T) {
-	p1 := &Proxy{URL: "alive", Alive: true, Score: 5}
-	p2 := &Proxy{URL: "dead", Alive: false, Score: 100}
+	p1 := &Proxy{URL: "alive", Score: 5}
+	p2 := &Proxy{URL: "dead", State: BreakerOpen, StateChangedAt: time.Now(), Score: 100}
 
 	pool := &Pool{Proxies: []*Proxy{p1, p2}}
 
@@ -153,3 +161,511 @@ func TestAllocate_IgnoresDeadProxies(t *testing.T) {
 		t.Fatalf("expected alive proxy, got %s", p.URL)
 	}
 }
+
+func TestAcquireRelease_TracksActiveLeases(t *testing.T) {
+	pool := newTestPool()
+
+	lease, err := pool.Acquire(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.ID == "" || lease.Proxy == nil {
+		t.Fatalf("incomplete lease: %+v", lease)
+	}
+	if lease.Proxy.ActiveLeases != 1 {
+		t.Fatalf("expected 1 active lease, got %d", lease.Proxy.ActiveLeases)
+	}
+
+	if err := pool.Release(lease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if lease.Proxy.ActiveLeases != 0 {
+		t.Fatalf("expected 0 active leases after release, got %d", lease.Proxy.ActiveLeases)
+	}
+
+	if err := pool.Release(lease.ID); err != ErrLeaseNotFound {
+		t.Fatalf("expected ErrLeaseNotFound on double release, got %v", err)
+	}
+}
+
+func TestExpireLeases_ReclaimsAbandoned(t *testing.T) {
+	pool := newTestPool()
+
+	lease, err := pool.Acquire(time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if n := pool.ExpireLeases(); n != 1 {
+		t.Fatalf("expected 1 expired lease, got %d", n)
+	}
+	if lease.Proxy.ActiveLeases != 0 {
+		t.Fatalf("expected expired lease to free its proxy, got %d active", lease.Proxy.ActiveLeases)
+	}
+	if err := pool.Release(lease.ID); err != ErrLeaseNotFound {
+		t.Fatalf("expected expired lease to be gone, got %v", err)
+	}
+}
+
+func TestExpireLeases_OnlyDueLeases(t *testing.T) {
+	pool := newTestPool()
+
+	var short []*Lease
+	long := map[string]bool{}
+	for i := range 6 {
+		ttl := time.Hour
+		if i%2 == 0 {
+			ttl = time.Millisecond
+		}
+		lease, err := pool.Acquire(ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl == time.Hour {
+			long[lease.ID] = true
+		} else {
+			short = append(short, lease)
+		}
+	}
+	// Releasing from the middle of the deadline order keeps it intact.
+	if err := pool.Release(short[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if n := pool.ExpireLeases(); n != 2 {
+		t.Fatalf("expected the 2 short leases still held to expire, got %d", n)
+	}
+	if len(pool.leases) != len(long) || len(pool.deadlines) != len(long) {
+		t.Fatalf("expected only the long leases left, got %d leases and %d deadlines", len(pool.leases), len(pool.deadlines))
+	}
+	for id := range long {
+		if err := pool.Release(id); err != nil {
+			t.Fatalf("expected the long lease to still be held, got %v", err)
+		}
+	}
+}
+
+func TestReport_DemotesWithoutKillingProxy(t *testing.T) {
+	pool := newTestPool()
+	pool.Feedback = FeedbackConfig{
+		FailureWeight: 1,
+		ErrorWeights:  map[string]float64{ErrorClassBanned: 3},
+	}
+	target := pool.Proxies[0]
+
+	if err := pool.Report(target.URL, Outcome{Success: false, ErrorClass: ErrorClassTimeout}); err != nil {
+		t.Fatal(err)
+	}
+	afterTimeout := target.Score
+
+	if err := pool.Report(target.URL, Outcome{Success: false, ErrorClass: ErrorClassBanned}); err != nil {
+		t.Fatal(err)
+	}
+	afterBan := target.Score
+
+	if afterTimeout >= 6 {
+		t.Fatalf("expected reported failure to lower score, got %.2f", afterTimeout)
+	}
+	if afterTimeout-afterBan <= 6-afterTimeout {
+		t.Fatalf("expected ban to weigh more than timeout: 6 -> %.2f -> %.2f", afterTimeout, afterBan)
+	}
+	if target.State != BreakerClosed {
+		t.Fatalf("two reports must not open the breaker, got %s", target.State)
+	}
+
+	if err := pool.Report("http://unknown:1", Outcome{Success: true}); err != ErrProxyNotFound {
+		t.Fatalf("expected ErrProxyNotFound, got %v", err)
+	}
+}
+
+func TestReleaseWithOutcome_ReportsAndFrees(t *testing.T) {
+	pool := newTestPool()
+
+	lease, err := pool.Acquire(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := lease.Proxy.Score
+
+	if err := pool.ReleaseWithOutcome(lease.ID, Outcome{Success: true, LatencyMS: 300}); err != nil {
+		t.Fatal(err)
+	}
+	if lease.Proxy.ActiveLeases != 0 {
+		t.Fatalf("expected lease to be released, got %d active", lease.Proxy.ActiveLeases)
+	}
+	if lease.Proxy.Score <= before {
+		t.Fatalf("expected success to raise score, %.2f -> %.2f", before, lease.Proxy.Score)
+	}
+	if lease.Proxy.SuccessCount != 1 {
+		t.Fatalf("expected SuccessCount=1, got %d", lease.Proxy.SuccessCount)
+	}
+}
+
+func TestReport_FeedsClientLatency(t *testing.T) {
+	pool := newTestPool()
+	pool.Proxies[0].LatencyScore = LatencyScoreConfig{Weight: 1, ReferenceMS: 1000}
+	target := pool.Proxies[0]
+
+	if err := pool.Report(target.URL, Outcome{Success: true, LatencyMS: 900}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Report(target.URL, Outcome{Success: false, LatencyMS: 30000, ErrorClass: ErrorClassTimeout}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Report(target.URL, Outcome{Success: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pool.Stats(target)
+	if stats.LatencyMS != 900 || stats.Latency.Samples != 1 {
+		t.Fatalf("expected only the reported success latency to be recorded, got %d ms, %d samples",
+			stats.LatencyMS, stats.Latency.Samples)
+	}
+
+	// A slow success gains less than a fast one.
+	fast := pool.Proxies[1]
+	fast.LatencyScore = target.LatencyScore
+	fast.Score, target.Score = 0, 0
+	if err := pool.Report(fast.URL, Outcome{Success: true, LatencyMS: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Report(target.URL, Outcome{Success: true, LatencyMS: 900}); err != nil {
+		t.Fatal(err)
+	}
+	if fast.Score <= target.Score {
+		t.Fatalf("expected the fast proxy to gain more, got %.2f vs %.2f", fast.Score, target.Score)
+	}
+}
+
+type memSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	saves    int
+	// block, when set, holds every SaveSession until it is closed.
+	block chan struct{}
+}
+
+func (m *memSessionStore) SaveSession(s Session) error {
+	if m.block != nil {
+		<-m.block
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saves++
+	m.sessions[s.Key] = s
+	return nil
+}
+
+func (m *memSessionStore) DeleteSession(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, key)
+	return nil
+}
+
+func TestAllocateSession_StickyWithFailover(t *testing.T) {
+	store := &memSessionStore{sessions: map[string]Session{}}
+	pool := newTestPool()
+	pool.SessionStore = store
+
+	first, failover, err := pool.AllocateSession("user-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failover {
+		t.Fatal("first allocation must not be a failover")
+	}
+
+	for range 5 {
+		p, failover, err := pool.AllocateSession("user-1", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != first || failover {
+			t.Fatalf("expected sticky proxy %s, got %s (failover=%t)", first.URL, p.URL, failover)
+		}
+	}
+
+	tripBreaker(first)
+
+	next, failover, err := pool.AllocateSession("user-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == first || !failover {
+		t.Fatalf("expected failover away from dead proxy, got %s (failover=%t)", next.URL, failover)
+	}
+	if store.sessions["user-1"].ProxyURL != next.URL {
+		t.Fatalf("expected store to follow failover, got %+v", store.sessions["user-1"])
+	}
+
+	if err := pool.DeleteSession("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Sessions()) != 0 || len(store.sessions) != 0 {
+		t.Fatal("expected session to be gone from pool and store")
+	}
+}
+
+func TestAllocateSession_SavesOnlyBindingsAndLongExtensions(t *testing.T) {
+	store := &memSessionStore{sessions: map[string]Session{}}
+	pool := newTestPool()
+	pool.SessionStore = store
+
+	for range 5 {
+		if _, _, err := pool.AllocateSession("user-1", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.saves != 1 {
+		t.Fatalf("expected only the binding to be saved, got %d saves", store.saves)
+	}
+
+	// Once a quarter of the TTL has passed, the next hit saves the new
+	// expiry.
+	pool.sessionMu.Lock()
+	pool.sessions["user-1"].savedExpiresAt = time.Now().Add(30 * time.Minute)
+	pool.sessionMu.Unlock()
+	if _, _, err := pool.AllocateSession("user-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if store.saves != 2 {
+		t.Fatalf("expected the extension to be saved, got %d saves", store.saves)
+	}
+}
+
+func TestAllocateSession_StickyHitsDontWaitForStore(t *testing.T) {
+	pool := newTestPool()
+	pool.SessionStore = &memSessionStore{sessions: map[string]Session{}}
+	if _, _, err := pool.AllocateSession("bound", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	store := &memSessionStore{sessions: map[string]Session{}, block: make(chan struct{})}
+	pool.SessionStore = store
+	defer close(store.block)
+
+	go func() { _, _, _ = pool.AllocateSession("new", time.Hour) }()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := pool.AllocateSession("bound", time.Hour)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a sticky hit waited for another session's save")
+	}
+}
+
+func TestAllocateSession_NotesDiversity(t *testing.T) {
+	pool := newSubnetPool()
+	pool.Diversity = DiversityConfig{WindowSeconds: 60}
+	first, _, err := pool.AllocateSession("user-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.mu.Lock()
+	clear(pool.diversity.lastUsed)
+	pool.mu.Unlock()
+
+	if _, _, err := pool.AllocateSession("user-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	pool.mu.Lock()
+	_, noted := pool.diversity.lastUsed[pool.subnet(first)]
+	pool.mu.Unlock()
+	if !noted {
+		t.Fatal("expected a sticky hit to count for the diversity window")
+	}
+}
+
+func TestRestoreSessions_SkipsExpired(t *testing.T) {
+	pool := newTestPool()
+	now := time.Now()
+
+	pool.RestoreSessions([]Session{
+		{Key: "live", ProxyURL: pool.Proxies[1].URL, ExpiresAt: now.Add(time.Hour)},
+		{Key: "stale", ProxyURL: pool.Proxies[0].URL, ExpiresAt: now.Add(-time.Hour)},
+	})
+
+	sessions := pool.Sessions()
+	if len(sessions) != 1 || sessions[0].Key != "live" {
+		t.Fatalf("expected only the live session, got %+v", sessions)
+	}
+
+	p, failover, err := pool.AllocateSession("live", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != pool.Proxies[1] || failover {
+		t.Fatalf("expected restored binding to be honoured, got %s (failover=%t)", p.URL, failover)
+	}
+}
+
+func TestAcquire_RespectsConcurrencyCap(t *testing.T) {
+	pool := newTestPool()
+	pool.MaxConcurrent = 1
+	pool.Proxies[1].MaxConcurrent = 2
+
+	for range 3 {
+		if _, err := pool.Acquire(time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := pool.Acquire(time.Minute); err != ErrAtCapacity {
+		t.Fatalf("expected ErrAtCapacity, got %v", err)
+	}
+	if _, err := pool.Allocate(); err != ErrAtCapacity {
+		t.Fatalf("expected Allocate to skip saturated proxies, got %v", err)
+	}
+
+	stats := pool.Snapshots()
+	if stats[0].ActiveLeases != 1 || stats[0].MaxConcurrent != 1 {
+		t.Fatalf("unexpected stats for pool-default proxy: %+v", stats[0])
+	}
+	if stats[1].ActiveLeases != 2 || stats[1].MaxConcurrent != 2 {
+		t.Fatalf("unexpected stats for capped proxy: %+v", stats[1])
+	}
+}
+
+func TestLoadConfig_MixedProxyEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := `
+health_check_url: "https://example.com"
+timeout_seconds: 2
+max_concurrent: 4
+proxies:
+  - "http://10.0.0.1:8080"
+  - url: "http://10.0.0.2:8080"
+    max_concurrent: 1
+    tags:
+      country: us
+`
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Proxies) != 2 {
+		t.Fatalf("expected 2 proxies, got %d", len(pool.Proxies))
+	}
+	if pool.MaxConcurrent != 4 {
+		t.Fatalf("expected pool default 4, got %d", pool.MaxConcurrent)
+	}
+	if pool.Proxies[0].URL != "http://10.0.0.1:8080" || pool.Proxies[0].MaxConcurrent != 0 {
+		t.Fatalf("unexpected plain entry: %+v", pool.Proxies[0])
+	}
+	if pool.Proxies[1].URL != "http://10.0.0.2:8080" || pool.Proxies[1].MaxConcurrent != 1 {
+		t.Fatalf("unexpected mapping entry: %+v", pool.Proxies[1])
+	}
+	if pool.Proxies[1].Tags["country"] != "us" {
+		t.Fatalf("expected tags to be loaded, got %v", pool.Proxies[1].Tags)
+	}
+}
+
+func TestAllocate_RateLimitSkipsAndReportsRetryAfter(t *testing.T) {
+	pool := newTestPool()
+	pool.RateLimit = RateLimitConfig{Requests: 1, IntervalSeconds: 60, Burst: 1}
+	pool.Proxies[1].RateLimit = RateLimitConfig{Requests: 2, IntervalSeconds: 60, Burst: 2}
+
+	for range 3 {
+		if _, err := pool.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := pool.Allocate()
+	var rl *RateLimitError
+	if !errors.As(err, &rl) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatal("expected RateLimitError to wrap ErrRateLimited")
+	}
+	if rl.RetryAfter <= 0 || rl.RetryAfter > 30*time.Second {
+		t.Fatalf("unexpected RetryAfter %s", rl.RetryAfter)
+	}
+
+	if pool.Proxies[0].UsageCount != 1 || pool.Proxies[1].UsageCount != 2 {
+		t.Fatalf("expected usage 1/2, got %d/%d", pool.Proxies[0].UsageCount, pool.Proxies[1].UsageCount)
+	}
+}
+
+func TestTokenBucket_Refills(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimitConfig{Requests: 60, IntervalSeconds: 60, Burst: 1}, now)
+
+	if b.wait(now) != 0 {
+		t.Fatal("expected a full bucket")
+	}
+	b.take(now)
+	if w := b.wait(now); w < 999*time.Millisecond || w > time.Second {
+		t.Fatalf("expected ~1s wait, got %s", w)
+	}
+	if b.wait(now.Add(time.Second)) != 0 {
+		t.Fatal("expected bucket to refill after one interval slice")
+	}
+}
+
+func TestAllocateWith_Criteria(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{
+			{URL: "http://10.0.0.1:80", Score: 9, LatencyMS: 900, Tags: map[string]string{"type": "dc"}},
+			{URL: "socks5://10.0.0.2:1080", Score: 7, LatencyMS: 150, Tags: map[string]string{"type": "res"}},
+			{URL: "http://10.0.0.3:80", Score: 2, LatencyMS: 100, Tags: map[string]string{"type": "res"}},
+		},
+	}
+	ctx := context.Background()
+	minScore := 5.0
+
+	cases := []struct {
+		name string
+		req  AllocationRequest
+		want string
+	}{
+		{"min score", AllocationRequest{MinScore: &minScore, MaxLatencyMS: 500}, "socks5://10.0.0.2:1080"},
+		{"protocol", AllocationRequest{Protocol: "http", MaxLatencyMS: 500}, "http://10.0.0.3:80"},
+		{"exclude", AllocationRequest{Exclude: []string{"http://10.0.0.1:80"}}, "socks5://10.0.0.2:1080"},
+		{"prefer tags", AllocationRequest{PreferTags: []Tag{{"type", "res"}}}, "socks5://10.0.0.2:1080"},
+		{"prefer missing tag falls back", AllocationRequest{PreferTags: []Tag{{"type", "mobile"}}}, "http://10.0.0.1:80"},
+	}
+	for _, tc := range cases {
+		a, err := pool.AllocateWith(ctx, tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if a.Proxy.URL != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, a.Proxy.URL)
+		}
+	}
+}
+
+func TestAllocateWith_NoMatchVersusNoAlive(t *testing.T) {
+	pool := newTestPool()
+	ctx := context.Background()
+
+	if _, err := pool.AllocateWith(ctx, AllocationRequest{Protocol: "socks5"}); !errors.Is(err, ErrNoMatch) {
+		t.Fatalf("expected ErrNoMatch, got %v", err)
+	}
+
+	tripBreaker(pool.Proxies[0])
+	tripBreaker(pool.Proxies[1])
+	if _, err := pool.AllocateWith(ctx, AllocationRequest{Protocol: "http"}); !errors.Is(err, ErrNoAliveProxies) {
+		t.Fatalf("expected ErrNoAliveProxies, got %v", err)
+	}
+
+	empty := &Pool{}
+	if _, err := empty.AllocateWith(ctx, AllocationRequest{Protocol: "http"}); !errors.Is(err, ErrNoAliveProxies) {
+		t.Fatalf("expected ErrNoAliveProxies for an empty pool, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"log"
+	"math/rand/v2"
+	"slices"
+)
+
+// ProbationConfig keeps proxies added to a pool out of regular
+// allocation until they have proven themselves in health checks.
+type ProbationConfig struct {
+	// Checks is how many consecutive passing health checks end
+	// probation. Zero, the default, disables probation.
+	Checks int `yaml:"checks"`
+	// TrafficShare is the share of allocations (0..1) offered to proxies
+	// on probation. The default 0 keeps them out of allocation entirely.
+	TrafficShare float64 `yaml:"traffic_share"`
+}
+
+func (c ProbationConfig) checks() int {
+	return max(c.Checks, 0)
+}
+
+func (c ProbationConfig) enabled() bool {
+	return c.checks() > 0
+}
+
+func (c ProbationConfig) trafficShare() float64 {
+	return min(max(c.TrafficShare, 0), 1)
+}
+
+// probationTrial decides whether this allocation goes to a proxy on
+// probation, see ProbationConfig.TrafficShare.
+func (p *Pool) probationTrial() bool {
+	share := p.Probation.trafficShare()
+	return share > 0 && rand.Float64() < share
+}
+
+// probationMembers returns the proxies on probation, so a trial doesn't
+// scan the whole pool. The list is rebuilt when Proxies changes and
+// otherwise only shrinks: a member never goes back on probation, so
+// graduates are dropped as they are found. Must be called with p.mu held.
+func (p *Pool) probationMembers() []*Proxy {
+	if p.probation == nil {
+		p.probation = make([]*Proxy, 0)
+		for _, proxy := range p.Proxies {
+			proxy.mu.Lock()
+			if proxy.OnProbation {
+				p.probation = append(p.probation, proxy)
+			}
+			proxy.mu.Unlock()
+		}
+		return p.probation
+	}
+	p.probation = slices.DeleteFunc(p.probation, func(proxy *Proxy) bool {
+		proxy.mu.Lock()
+		defer proxy.mu.Unlock()
+		return !proxy.OnProbation
+	})
+	return p.probation
+}
+
+// probationCheck records a health check result against probation and
+// ends it after enough consecutive passes. Must be called with p.mu held.
+func (p *Proxy) probationCheck(success bool) {
+	if !p.OnProbation {
+		return
+	}
+	if !success {
+		p.ProbationPasses = 0
+		return
+	}
+	p.ProbationPasses++
+	if p.ProbationPasses >= p.Probation.checks() {
+		p.OnProbation = false
+		p.ProbationPasses = 0
+		log.Printf("Proxy %s passed probation", p.URL)
+	}
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProbation_NewProxiesWaitForPassingChecks(t *testing.T) {
+	pool := newCheckedPoolWith(t, 2, PoolConfig{Probation: ProbationConfig{Checks: 2}})
+	for _, p := range pool.Proxies {
+		if !p.OnProbation {
+			t.Fatalf("expected %s to start on probation", p.URL)
+		}
+	}
+	recheck := func() {
+		for _, p := range pool.Proxies {
+			p.NextCheck = time.Time{}
+		}
+		pool.HealthCheck(time.Second)
+	}
+
+	if _, err := pool.Allocate(); !errors.Is(err, ErrNoAliveProxies) {
+		t.Fatalf("expected untested proxies to be skipped, got %v", err)
+	}
+
+	recheck()
+	if c := pool.Counts(); c.Probation != 2 {
+		t.Fatalf("expected both proxies still on probation after one check, got %+v", c)
+	}
+
+	// A failed check starts the count over.
+	slow, fast := pool.Proxies[0], pool.Proxies[1]
+	slow.recordFailure("test", nil, sourceHealthCheck, 1)
+	if slow.ProbationPasses != 0 {
+		t.Fatalf("expected the failure to reset passes, got %d", slow.ProbationPasses)
+	}
+
+	recheck()
+	if !slow.OnProbation || fast.OnProbation {
+		t.Fatalf("expected only the proxy with 2 consecutive passes to graduate, got %v %v",
+			slow.OnProbation, fast.OnProbation)
+	}
+	for range 5 {
+		if got, err := pool.Allocate(); err != nil || got != fast {
+			t.Fatalf("expected the graduated proxy, got %v, %v", got, err)
+		}
+	}
+	if stats := pool.Publish().Stats; !stats[0].Probation || stats[0].ProbationPasses != 1 || stats[1].Probation {
+		t.Fatalf("unexpected probation stats: %+v", stats)
+	}
+}
+
+func TestProbation_TrafficShare(t *testing.T) {
+	pool := newTestPool()
+	novice := pool.Proxies[1]
+	novice.OnProbation = true
+	pool.Probation = ProbationConfig{TrafficShare: 0.2}
+
+	const trials = 2000
+	hits := 0
+	for range trials {
+		p, err := pool.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p == novice {
+			hits++
+		}
+	}
+	if share := float64(hits) / trials; share < 0.15 || share > 0.25 {
+		t.Fatalf("expected about 20%% of traffic on probation, got %.2f", share)
+	}
+
+	// With nothing else alive the share is a cap, not a fallback.
+	tripBreaker(pool.Proxies[0])
+	misses := 0
+	for range 50 {
+		if _, err := pool.Allocate(); errors.Is(err, ErrNoAliveProxies) {
+			misses++
+		}
+	}
+	if misses == 0 || misses == 50 {
+		t.Fatalf("expected only the trial share to reach the probation proxy, %d/50 misses", misses)
+	}
+}
+
+func TestProbation_Disabled(t *testing.T) {
+	// Probation is opt-in: the zero config and negative checks both
+	// leave new proxies allocatable straight away.
+	for _, cfg := range []ProbationConfig{{}, {Checks: -1}} {
+		pool, err := NewPool("p", PoolConfig{
+			Probation: cfg,
+			Proxies:   []ProxyConfig{{URL: "http://10.0.0.1:8080"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pool.Proxies[0].OnProbation {
+			t.Fatalf("expected no probation with %+v", cfg)
+		}
+		if _, err := pool.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProbation_MembersAndAliveCounts(t *testing.T) {
+	pool := newTestPool()
+	novice := pool.Proxies[1]
+	novice.OnProbation = true
+
+	pool.mu.Lock()
+	members := pool.probationMembers()
+	pool.mu.Unlock()
+	if len(members) != 1 || members[0] != novice {
+		t.Fatalf("expected only the proxy on probation, got %v", members)
+	}
+	if c := pool.Counts(); c.Alive != 1 || c.Probation != 1 {
+		t.Fatalf("expected the proxy on probation out of the alive count, got %+v", c)
+	}
+	if alive := pool.Publish().Alive; len(alive) != 1 || alive[0] != pool.Proxies[0] {
+		t.Fatalf("expected the proxy on probation out of the alive list, got %v", alive)
+	}
+
+	novice.mu.Lock()
+	novice.OnProbation = false
+	novice.mu.Unlock()
+	pool.mu.Lock()
+	members = pool.probationMembers()
+	pool.mu.Unlock()
+	if len(members) != 0 {
+		t.Fatalf("expected the graduate to leave the list, got %v", members)
+	}
+	if c := pool.Counts(); c.Alive != 2 {
+		t.Fatalf("expected the graduate to count as alive, got %+v", c)
+	}
+}
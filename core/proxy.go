@@ -3,39 +3,141 @@ package core
 import (
 	"context"
 	"log"
+	"maps"
 	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Proxy represents a single upstream proxy and maintains:
-//   - health information
+//   - health information (circuit breaker state)
 //   - score (quality/priority)
 //   - latency statistics
-//   - usage counts
+//   - usage counts and active leases
 //   - internal http.Client configured to route through the proxy
 //
 // All mutable fields are protected by the internal mutex (mu).
 type Proxy struct {
-	URL          string
-	Alive        bool
-	LastTest     time.Time
+	URL string
+	// State is the circuit breaker state; StateChangedAt is the time of
+	// the last transition.
+	State               BreakerState
+	StateChangedAt      time.Time
+	ConsecutiveFailures int
+	// Breaker tunes the state machine, zero fields use the defaults.
+	Breaker BreakerConfig
+	// LatencyScore controls how much latency discounts successes.
+	LatencyScore LatencyScoreConfig
+	// Scoring selects the model that turns outcomes into Score.
+	Scoring ScoringConfig
+	// OnProbation keeps a new proxy out of regular allocation until it
+	// has passed Probation.Checks consecutive health checks;
+	// ProbationPasses counts them.
+	OnProbation     bool
+	ProbationPasses int
+	Probation       ProbationConfig
+	LastTest        time.Time
+	// NextCheck is when HealthCheck will test this proxy again.
+	NextCheck    time.Time
 	CheckURL     string
 	Timeout      time.Duration
 	UsageCount   int
+	ActiveLeases int
+	// MaxConcurrent caps ActiveLeases. Zero defers to the pool default.
+	MaxConcurrent int
+	// Tier is the proxy's priority level, 0 being the highest; see
+	// TierConfig.
+	Tier int
+	// ExpiresAt is when a rented proxy stops working. Allocation skips
+	// it from then on and RetireExpired takes it out of the pool. Zero
+	// means it never expires.
+	ExpiresAt time.Time
+	// Tags are free-form labels (country, type, provider, ...) that
+	// allocation can filter on.
+	Tags map[string]string
+	// RateLimit overrides the pool's default request budget when set.
+	RateLimit RateLimitConfig
+	// DomainScores holds per-target-domain scores keyed by normalized
+	// domain, see DomainScore.
+	DomainScores map[string]DomainScore
 	FailCount    int
 	SuccessCount int
 	Score        float64
-	mu           sync.Mutex
-	transport    *http.Transport
-	client       *http.Client
-	LatencyMS    int
+	// ScoredAt is when Score was last aged, see
+	// ScoringConfig.HalfLifeSeconds.
+	ScoredAt time.Time
+	mu       sync.Mutex
+	limiter  *tokenBucket
+	// halfOpenTrials counts allocations let through while half-open.
+	halfOpenTrials int
+	id             string
+	idOnce         sync.Once
+	addr           netip.Addr
+	addrOnce       sync.Once
+	// subnet caches Pool.subnet for the prefixes it was computed with.
+	subnet atomic.Pointer[subnetKey]
+	// removed is set once Remove has taken the proxy out of its pool.
+	removed bool
+	// persistMu serializes saving the proxy with removing it, see
+	// Persist.
+	persistMu sync.Mutex
+	latency   *latencyWindow
+	recent    *outcomeWindow
+	// banditWindow holds the outcomes the bandit strategies look at, see
+	// banditCounts.
+	banditWindow *outcomeWindow
+	scoreModel   Scorer
+	// index is the pool index this proxy reports score changes to.
+	index *scoreIndex
+	// domainScorers hold the scoring model state behind DomainScores.
+	domainScorers map[string]Scorer
+	transport     *http.Transport
+	client        *http.Client
+	LatencyMS     int
 }
 
+// Score bounds shared by the scoring model and the strategies that weigh
+// proxies by score.
+const (
+	minScore = -5.0
+	maxScore = 10.0
+)
+
+// outcomeSource tells the scoring path where a result came from.
+// Both sources move the score and the breaker; only health checks
+// update LastTest and the measured latency.
+type outcomeSource int
+
+const (
+	sourceHealthCheck outcomeSource = iota
+	sourceClient
+)
+
+// ProxySnapshot is a consistent copy of a proxy's persisted state,
+// taken under its lock.
 type ProxySnapshot struct {
-	URL      string
-	Alive    bool
-	LastTest time.Time
+	URL                 string
+	Alive               bool
+	State               BreakerState
+	StateChangedAt      time.Time
+	ConsecutiveFailures int
+	LastTest            time.Time
+	Score               float64
+	ScoredAt            time.Time
+	UsageCount          int
+	FailCount           int
+	SuccessCount        int
+	LatencyMS           int
+	OnProbation         bool
+	ProbationPasses     int
+	MaxConcurrent       int
+	Tier                int
+	ExpiresAt           time.Time
+	RateLimit           RateLimitConfig
+	Tags                map[string]string
+	Removed             bool
 }
 
 func (p *Proxy) Test(timeout time.Duration) bool {
@@ -45,7 +147,7 @@ func (p *Proxy) Test(timeout time.Duration) bool {
 	p.mu.Unlock()
 
 	if client == nil {
-		p.recordFailure("no http client", nil)
+		p.recordFailure("no http client", nil, sourceHealthCheck, 1)
 		return false
 	}
 
@@ -54,7 +156,7 @@ func (p *Proxy) Test(timeout time.Duration) bool {
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
 	if err != nil {
-		p.recordFailure("request creation failed", err)
+		p.recordFailure("request creation failed", err, sourceHealthCheck, 1)
 		return false
 	}
 
@@ -63,78 +165,111 @@ func (p *Proxy) Test(timeout time.Duration) bool {
 	latency := time.Since(start).Milliseconds()
 
 	if err != nil {
-		p.recordFailure("request failed", err)
+		p.recordFailure("request failed", err, sourceHealthCheck, 1)
 		return false
 	}
 	defer resp.Body.Close()
 
 	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
 	if ok {
-		p.recordSuccess(int(latency))
+		p.recordSuccess(int(latency), sourceHealthCheck, 1)
 	} else {
-		p.recordFailure("non-200 status", nil)
+		p.recordFailure("non-200 status", nil, sourceHealthCheck, 1)
 	}
 	return ok
 }
 
-func (p *Proxy) recordSuccess(latencyMS int) {
+// recordSuccess applies a successful outcome. weight scales the score
+// gain so client reports can count for more or less than a health check.
+func (p *Proxy) recordSuccess(latencyMS int, src outcomeSource, weight float64) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	const successGain = 0.4
-	const decay = 0.995
-	const maxScore = 10.0
+	now := time.Now()
+	if src == sourceHealthCheck {
+		p.recordLatency(latencyMS)
+		p.LastTest = now
+		p.probationCheck(true)
+	} else if latencyMS > 0 {
+		// Clients only report latency when they measured it.
+		p.recordLatency(latencyMS)
+	}
 
 	p.SuccessCount++
-	p.LatencyMS = latencyMS
-
-	p.Score = p.Score*decay + successGain
-	if p.Score > maxScore {
-		p.Score = maxScore
-	}
+	p.recordOutcome(true)
+	p.age(now)
+	p.Score = p.scorer().Observe(p.Score, ScoreOutcome{
+		Success:  true,
+		Weight:   weight * p.LatencyScore.factor(p.latencyEstimate()),
+		Failures: p.FailCount,
+	})
+	p.scoreChanged()
 
-	p.Alive = true
-	p.LastTest = time.Now()
+	p.breakerSuccess(now)
 }
 
-func (p *Proxy) recordFailure(reason string, err error) {
+// recordFailure applies a failed outcome, see recordSuccess for weight.
+func (p *Proxy) recordFailure(reason string, err error, src outcomeSource, weight float64) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	const failurePenalty = 0.7
-	const decay = 0.995
-	const minScore = -5.0
-	const softCap = 3
-
+	now := time.Now()
 	p.FailCount++
+	p.recordOutcome(false)
+	p.age(now)
+	p.Score = p.scorer().Observe(p.Score, ScoreOutcome{Weight: weight, Failures: p.FailCount})
+	p.scoreChanged()
 
-	penalty := failurePenalty
-	if p.FailCount <= softCap {
-		penalty *= 0.5
-	}
-
-	p.Score = p.Score*decay - penalty
-	if p.Score < minScore {
-		p.Score = minScore
+	what := "check failed"
+	if src == sourceHealthCheck {
+		p.LastTest = now
+		p.probationCheck(false)
+	} else {
+		what = "report failed"
 	}
-
-	p.Alive = false
-	p.LastTest = time.Now()
+	p.breakerFailure(now)
 
 	if err != nil {
-		log.Printf("Proxy check failed for %s: %s (%v)", p.URL, reason, err)
+		log.Printf("Proxy %s for %s: %s (%v)", what, p.URL, reason, err)
 	} else {
-		log.Printf("Proxy check failed for %s: %s", p.URL, reason)
+		log.Printf("Proxy %s for %s: %s", what, p.URL, reason)
+	}
+}
+
+// scorer returns the proxy's scoring model, creating it from Scoring on
+// first use. Must be called with p.mu held.
+func (p *Proxy) scorer() Scorer {
+	if p.scoreModel == nil {
+		p.scoreModel = p.Scoring.newScorer(p.Score)
 	}
+	return p.scoreModel
 }
 
 func (p *Proxy) Snapshot() ProxySnapshot {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.age(time.Now())
 	return ProxySnapshot{
-		URL:      p.URL,
-		Alive:    p.Alive,
-		LastTest: p.LastTest,
+		URL:                 p.URL,
+		Alive:               p.alive(time.Now()),
+		State:               p.State,
+		StateChangedAt:      p.StateChangedAt,
+		ConsecutiveFailures: p.ConsecutiveFailures,
+		LastTest:            p.LastTest,
+		Score:               p.Score,
+		ScoredAt:            p.ScoredAt,
+		UsageCount:          p.UsageCount,
+		FailCount:           p.FailCount,
+		SuccessCount:        p.SuccessCount,
+		LatencyMS:           p.LatencyMS,
+		OnProbation:         p.OnProbation,
+		ProbationPasses:     p.ProbationPasses,
+		MaxConcurrent:       p.MaxConcurrent,
+		Tier:                p.Tier,
+		ExpiresAt:           p.ExpiresAt,
+		RateLimit:           p.RateLimit,
+		Tags:                maps.Clone(p.Tags),
+		Removed:             p.removed,
 	}
 }
 
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrRateLimited = errors.New("all alive proxies are rate limited")
+
+// RateLimitError is returned by allocation when every alive proxy has used
+// up its request budget. RetryAfter is the time until the first of them
+// gets a token back.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error { return ErrRateLimited }
+
+// RateLimitConfig allows Requests allocations per IntervalSeconds, with
+// up to Burst of them back to back. Burst defaults to Requests.
+// A zero Requests disables the limit.
+type RateLimitConfig struct {
+	Requests        int `yaml:"requests" json:"requests"`
+	IntervalSeconds int `yaml:"interval_seconds" json:"interval_seconds"`
+	Burst           int `yaml:"burst" json:"burst"`
+}
+
+func (c RateLimitConfig) enabled() bool {
+	return c.Requests > 0 && c.IntervalSeconds > 0
+}
+
+// tokenBucket is not safe for concurrent use; it lives under Proxy.mu.
+type tokenBucket struct {
+	cfg    RateLimitConfig
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(cfg RateLimitConfig, now time.Time) *tokenBucket {
+	burst := float64(cfg.Burst)
+	if burst <= 0 {
+		burst = float64(cfg.Requests)
+	}
+	return &tokenBucket{
+		cfg:    cfg,
+		rate:   float64(cfg.Requests) / float64(cfg.IntervalSeconds),
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available, zero if one is
+// available right now.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+// limiter returns the token bucket for proxy, creating it on first use
+// from the proxy's own limit or the pool default. It returns nil when
+// the proxy is not rate limited. Must be called with proxy.mu held.
+func (p *Pool) limiter(proxy *Proxy, now time.Time) *tokenBucket {
+	cfg := proxy.RateLimit
+	if !cfg.enabled() {
+		cfg = p.RateLimit
+	}
+	if !cfg.enabled() {
+		return nil
+	}
+	if proxy.limiter == nil || proxy.limiter.cfg != cfg {
+		proxy.limiter = newTokenBucket(cfg, now)
+	}
+	return proxy.limiter
+}
+
+// throttled reports how long proxy must wait for its next token.
+// Must be called with proxy.mu held.
+func (p *Pool) throttled(proxy *Proxy, now time.Time) time.Duration {
+	if b := p.limiter(proxy, now); b != nil {
+		return b.wait(now)
+	}
+	return 0
+}
+
+// consumeToken must be called with proxy.mu held.
+func (p *Pool) consumeToken(proxy *Proxy, now time.Time) {
+	if b := p.limiter(proxy, now); b != nil {
+		b.take(now)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoMatch means the pool has proxies, but none of them satisfies the
+// request's criteria. Unlike ErrNoAliveProxies, retrying the same request
+// later is unlikely to help.
+var ErrNoMatch = errors.New("no proxy matches the allocation criteria")
+
+// AllocationRequest describes what the caller needs from a proxy.
+// The zero value matches every alive proxy.
+type AllocationRequest struct {
+	// Domain is the target site the proxy will be used against. When a
+	// proxy has a score for it, that score replaces the global one for
+	// ranking and for MinScore.
+	Domain string
+	// MinScore, when set, excludes proxies scoring below it.
+	MinScore *float64
+	// MaxLatencyMS excludes proxies whose smoothed (EWMA) latency is above
+	// it. Zero means no limit; proxies without a measurement pass.
+	MaxLatencyMS int
+	// Protocol restricts the proxy URL scheme (http, https, socks5, ...).
+	Protocol string
+	// Exclude lists proxy URLs the caller doesn't want, e.g. ones it has
+	// just been banned on.
+	Exclude []string
+	// Tags must all be present on the proxy.
+	Tags []Tag
+	// PreferTags narrow the choice to proxies carrying all of them when
+	// any such proxy is available, and are ignored otherwise.
+	PreferTags []Tag
+	// SessionKey pins the allocation to a sticky session, see
+	// AllocateSession. SessionTTL defaults to DefaultSessionTTL.
+	SessionKey string
+	SessionTTL time.Duration
+
+	// avoid holds the subnets and ASNs already handed out in the current
+	// AllocateBatch, and excluded the proxy URLs.
+	avoid    map[string]bool
+	excluded map[string]bool
+}
+
+// Allocation is the result of AllocateWith.
+type Allocation struct {
+	Proxy *Proxy
+	// Failover is set when a sticky session had to move to a new proxy.
+	Failover bool
+}
+
+// AllocateWith selects a proxy satisfying req.
+//
+// It returns ErrNoMatch when no proxy in the pool satisfies the criteria,
+// ErrNoAliveProxies when matching proxies exist but none of them is alive
+// (or the pool is empty), and ErrAtCapacity or a *RateLimitError when
+// the matching proxies are alive but busy.
+//
+// Thread-safe.
+func (p *Pool) AllocateWith(ctx context.Context, req AllocationRequest) (*Allocation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req.Domain = NormalizeDomain(req.Domain)
+
+	if req.SessionKey != "" {
+		return p.allocateSession(req)
+	}
+
+	proxy, err := p.allocate(false, &req)
+	if err != nil {
+		return nil, err
+	}
+	return &Allocation{Proxy: proxy}, nil
+}
+
+// matches reports whether proxy satisfies the hard criteria of req.
+// Must be called with proxy.mu held.
+func (req *AllocationRequest) matches(proxy *Proxy) bool {
+	if req.MinScore != nil && proxy.scoreFor(req.Domain) < *req.MinScore {
+		return false
+	}
+	if req.MaxLatencyMS > 0 && proxy.latencyEstimate() > float64(req.MaxLatencyMS) {
+		return false
+	}
+	if req.Protocol != "" && !strings.EqualFold(proxyScheme(proxy.URL), req.Protocol) {
+		return false
+	}
+	if req.excludes(proxy.URL) {
+		return false
+	}
+	return proxy.hasTags(req.Tags)
+}
+
+// excludes reports whether the caller excluded proxyURL.
+func (req *AllocationRequest) excludes(proxyURL string) bool {
+	return req.excluded[proxyURL] || slices.Contains(req.Exclude, proxyURL)
+}
+
+// narrowToPreferred keeps only the candidates carrying the preferred tags
+// when there is at least one of them.
+func (req *AllocationRequest) narrowToPreferred(candidates []Candidate, preferred []bool) []Candidate {
+	if len(req.PreferTags) == 0 || !slices.Contains(preferred, true) {
+		return candidates
+	}
+	out := make([]Candidate, 0, len(candidates))
+	for i, c := range candidates {
+		if preferred[i] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func proxyScheme(rawURL string) string {
+	scheme, _, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return ""
+	}
+	return scheme
+}
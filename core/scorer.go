@@ -0,0 +1,280 @@
+package core
+
+import "fmt"
+
+// Scorer turns the outcomes observed for a proxy into its score.
+// Observe is called with the proxy's current score and one outcome and
+// returns the new score, always within [minScore, maxScore]. Stateless
+// models derive the new score from current; stateful ones keep their own
+// evidence and may ignore it.
+//
+// A stateful Scorer tracks a single proxy (or a single proxy/domain
+// pair); use ScoringConfig.NewScorer to get one per proxy. Calls are
+// serialized by the proxy's mutex.
+type Scorer interface {
+	Name() string
+	Observe(current float64, o ScoreOutcome) float64
+}
+
+// ScoreOutcome is one result as seen by a Scorer.
+type ScoreOutcome struct {
+	Success bool
+	// Weight scales how much the outcome counts; a health check is 1.
+	Weight float64
+	// Failures is the proxy's failure count including this outcome.
+	Failures int
+}
+
+const (
+	ScoringAdditive = "additive"
+	ScoringBeta     = "beta"
+	ScoringWindow   = "window"
+)
+
+// ScoringConfig selects and tunes the scoring model of a pool.
+// Zero fields use each model's defaults.
+type ScoringConfig struct {
+	Model    string         `yaml:"model"`
+	Additive AdditiveConfig `yaml:"additive"`
+	Beta     BetaConfig     `yaml:"beta"`
+	Window   WindowConfig   `yaml:"window"`
+	// HalfLifeSeconds makes score aging time-based: a score moves halfway
+	// back to Neutral every HalfLifeSeconds without new outcomes, and the
+	// models' per-outcome decay is turned off. Zero keeps the per-outcome
+	// decay.
+	HalfLifeSeconds float64 `yaml:"half_life_seconds"`
+	// Neutral is the score aging moves toward. Unset means the score new
+	// proxies start with.
+	Neutral *float64 `yaml:"neutral"`
+}
+
+// NewScorer returns a scorer for the configured model seeded with the
+// proxy's current score. An empty model selects the additive one.
+func (c ScoringConfig) NewScorer(seed float64) (Scorer, error) {
+	if c.halfLife() > 0 {
+		c.Additive.Decay = 1
+		c.Beta.Decay = 1
+	}
+	switch c.Model {
+	case "", ScoringAdditive:
+		return Additive{Config: c.Additive}, nil
+	case ScoringBeta:
+		return NewBeta(c.Beta, seed), nil
+	case ScoringWindow:
+		return NewWindow(c.Window, seed), nil
+	default:
+		return nil, fmt.Errorf("unknown scoring model %q", c.Model)
+	}
+}
+
+// newScorer is NewScorer for configs already validated by NewPool, so an
+// unknown model can only come from a hand-built Proxy and falls back to
+// the additive model.
+func (c ScoringConfig) newScorer(seed float64) Scorer {
+	s, err := c.NewScorer(seed)
+	if err != nil {
+		return Additive{Config: c.Additive}
+	}
+	return s
+}
+
+func clampScore(s float64) float64 {
+	if s < minScore {
+		return minScore
+	}
+	if s > maxScore {
+		return maxScore
+	}
+	return s
+}
+
+// scoreFromRate maps a success rate in [0, 1] onto the score range.
+func scoreFromRate(rate float64) float64 {
+	return clampScore(minScore + rate*(maxScore-minScore))
+}
+
+// rateFromScore is the inverse of scoreFromRate.
+func rateFromScore(score float64) float64 {
+	return (clampScore(score) - minScore) / (maxScore - minScore)
+}
+
+// AdditiveConfig tunes the additive model.
+type AdditiveConfig struct {
+	SuccessGain    float64 `yaml:"success_gain"`
+	FailurePenalty float64 `yaml:"failure_penalty"`
+	Decay          float64 `yaml:"decay"`
+	// SoftCap halves the penalty of the first SoftCap failures so a
+	// single blip doesn't sink a good proxy. Negative disables it.
+	SoftCap int `yaml:"soft_cap"`
+}
+
+const (
+	defaultSuccessGain    = 0.4
+	defaultFailurePenalty = 0.7
+	defaultScoreDecay     = 0.995
+	defaultSoftCap        = 3
+)
+
+// Additive is the original scoring model: every result decays the
+// previous score slightly, a success adds a fixed gain and a failure
+// subtracts a penalty. It is stateless.
+type Additive struct {
+	Config AdditiveConfig
+}
+
+func (Additive) Name() string { return ScoringAdditive }
+
+func (a Additive) Observe(current float64, o ScoreOutcome) float64 {
+	gain := orDefault(a.Config.SuccessGain, defaultSuccessGain)
+	penalty := orDefault(a.Config.FailurePenalty, defaultFailurePenalty)
+	decay := orDefault(a.Config.Decay, defaultScoreDecay)
+	softCap := a.Config.SoftCap
+	if softCap == 0 {
+		softCap = defaultSoftCap
+	}
+
+	if o.Success {
+		return clampScore(current*decay + gain*o.Weight)
+	}
+
+	p := penalty * o.Weight
+	if o.Failures <= softCap {
+		p *= 0.5
+	}
+	return clampScore(current*decay - p)
+}
+
+func orDefault(v, def float64) float64 {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// BetaConfig tunes the Beta model.
+type BetaConfig struct {
+	// PriorStrength is how many outcomes' worth of evidence the seed
+	// score is given. Defaults to 10.
+	PriorStrength float64 `yaml:"prior_strength"`
+	// Decay multiplies the evidence before each outcome so old results
+	// fade out; the effective memory is about 1/(1-Decay) outcomes.
+	// Defaults to 0.98. Use 1 to keep everything.
+	Decay float64 `yaml:"decay"`
+}
+
+const (
+	defaultBetaPriorStrength = 10
+	defaultBetaDecay         = 0.98
+)
+
+// Beta estimates the success rate as the mean of a Beta(α, β) posterior,
+// with α and β the (decayed, weighted) success and failure counts plus a
+// prior derived from the seed score. The score is the rate mapped onto
+// [minScore, maxScore]: a proxy with no evidence either way keeps its
+// seed, and one with a long clean record approaches maxScore.
+type Beta struct {
+	Config BetaConfig
+	Alpha  float64
+	Beta   float64
+}
+
+// NewBeta returns a Beta scorer whose prior mean matches seed.
+func NewBeta(cfg BetaConfig, seed float64) *Beta {
+	n := orDefault(cfg.PriorStrength, defaultBetaPriorStrength)
+	rate := rateFromScore(seed)
+	return &Beta{Config: cfg, Alpha: rate * n, Beta: (1 - rate) * n}
+}
+
+func (*Beta) Name() string { return ScoringBeta }
+
+func (b *Beta) Observe(_ float64, o ScoreOutcome) float64 {
+	decay := orDefault(b.Config.Decay, defaultBetaDecay)
+	if decay > 1 {
+		decay = 1
+	}
+	b.Alpha *= decay
+	b.Beta *= decay
+
+	if o.Success {
+		b.Alpha += o.Weight
+	} else {
+		b.Beta += o.Weight
+	}
+	return b.score()
+}
+
+func (b *Beta) score() float64 {
+	total := b.Alpha + b.Beta
+	if total <= 0 {
+		return scoreFromRate(0.5)
+	}
+	return scoreFromRate(b.Alpha / total)
+}
+
+// WindowConfig tunes the sliding-window model.
+type WindowConfig struct {
+	// Size is the number of recent outcomes considered. Defaults to 50.
+	Size int `yaml:"size"`
+}
+
+const defaultWindowSize = 50
+
+// Window scores a proxy on the weighted success ratio of its last Size
+// outcomes. Until the window is full the empty slots count at the seed
+// score's rate, so a fresh proxy isn't judged on its first result.
+type Window struct {
+	Config  WindowConfig
+	seed    float64
+	neutral float64
+	slots   []windowSlot
+	next    int
+}
+
+type windowSlot struct {
+	success bool
+	weight  float64
+	// faded is weight that has aged into the neutral rate, see Age.
+	faded float64
+}
+
+// NewWindow returns a Window scorer seeded with seed.
+func NewWindow(cfg WindowConfig, seed float64) *Window {
+	return &Window{Config: cfg, seed: rateFromScore(seed)}
+}
+
+func (*Window) Name() string { return ScoringWindow }
+
+func (w *Window) size() int {
+	if w.Config.Size <= 0 {
+		return defaultWindowSize
+	}
+	return w.Config.Size
+}
+
+func (w *Window) Observe(_ float64, o ScoreOutcome) float64 {
+	slot := windowSlot{success: o.Success, weight: o.Weight}
+	if len(w.slots) < w.size() {
+		w.slots = append(w.slots, slot)
+	} else {
+		w.slots[w.next] = slot
+		w.next = (w.next + 1) % len(w.slots)
+	}
+	return w.score()
+}
+
+func (w *Window) score() float64 {
+	empty := float64(w.size() - len(w.slots))
+	good := w.seed * empty
+	total := empty
+	for _, s := range w.slots {
+		total += s.weight + s.faded
+		good += s.faded * w.neutral
+		if s.success {
+			good += s.weight
+		}
+	}
+	if total <= 0 {
+		return scoreFromRate(w.seed)
+	}
+	return scoreFromRate(good / total)
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestAdditive_MatchesHistoricalModel(t *testing.T) {
+	a := Additive{}
+
+	if got := a.Observe(6, ScoreOutcome{Success: true, Weight: 1}); !approx(got, 6*0.995+0.4) {
+		t.Fatalf("success: got %v", got)
+	}
+	// The first three failures are halved.
+	if got := a.Observe(6, ScoreOutcome{Weight: 1, Failures: 3}); !approx(got, 6*0.995-0.35) {
+		t.Fatalf("soft-capped failure: got %v", got)
+	}
+	if got := a.Observe(6, ScoreOutcome{Weight: 1, Failures: 4}); !approx(got, 6*0.995-0.7) {
+		t.Fatalf("full failure: got %v", got)
+	}
+	if got := a.Observe(maxScore, ScoreOutcome{Success: true, Weight: 5}); got != maxScore {
+		t.Fatalf("expected clamp at maxScore, got %v", got)
+	}
+	if got := a.Observe(minScore, ScoreOutcome{Weight: 5, Failures: 10}); got != minScore {
+		t.Fatalf("expected clamp at minScore, got %v", got)
+	}
+}
+
+func TestAdditive_Configurable(t *testing.T) {
+	a := Additive{Config: AdditiveConfig{SuccessGain: 1, FailurePenalty: 2, Decay: 1, SoftCap: -1}}
+
+	if got := a.Observe(0, ScoreOutcome{Success: true, Weight: 0.5}); !approx(got, 0.5) {
+		t.Fatalf("success: got %v", got)
+	}
+	if got := a.Observe(0, ScoreOutcome{Weight: 1, Failures: 1}); !approx(got, -2) {
+		t.Fatalf("soft cap should be disabled, got %v", got)
+	}
+}
+
+func TestBeta_SeedAndConvergence(t *testing.T) {
+	b := NewBeta(BetaConfig{}, 6)
+	if !approx(b.score(), 6) {
+		t.Fatalf("expected the prior to reproduce the seed, got %v", b.score())
+	}
+
+	var s float64
+	for range 500 {
+		s = b.Observe(0, ScoreOutcome{Success: true, Weight: 1})
+	}
+	if s < maxScore-0.5 {
+		t.Fatalf("expected a clean record to approach maxScore, got %v", s)
+	}
+
+	// With decay, a run of failures overrides the old record.
+	for range 200 {
+		s = b.Observe(0, ScoreOutcome{Weight: 1})
+	}
+	if s > minScore+0.5 {
+		t.Fatalf("expected a run of failures to approach minScore, got %v", s)
+	}
+}
+
+func TestBeta_SuccessRate(t *testing.T) {
+	b := NewBeta(BetaConfig{PriorStrength: 2, Decay: 1}, scoreFromRate(0.5))
+	for range 30 {
+		b.Observe(0, ScoreOutcome{Success: true, Weight: 1})
+		b.Observe(0, ScoreOutcome{Weight: 1})
+		b.Observe(0, ScoreOutcome{Weight: 1})
+	}
+	// (1 + 30) / (2 + 90)
+	if want := scoreFromRate(31.0 / 92.0); !approx(b.score(), want) {
+		t.Fatalf("got %v, want %v", b.score(), want)
+	}
+}
+
+func TestWindow_SeedFillsEmptySlots(t *testing.T) {
+	w := NewWindow(WindowConfig{Size: 4}, scoreFromRate(0.5))
+
+	// One failure, three empty slots at 0.5: 1.5 / 4.
+	if got := w.Observe(0, ScoreOutcome{Weight: 1}); !approx(got, scoreFromRate(1.5/4)) {
+		t.Fatalf("got %v", got)
+	}
+	for range 3 {
+		w.Observe(0, ScoreOutcome{Success: true, Weight: 1})
+	}
+	if !approx(w.score(), scoreFromRate(0.75)) {
+		t.Fatalf("full window: got %v", w.score())
+	}
+}
+
+func TestWindow_ForgetsOldOutcomes(t *testing.T) {
+	w := NewWindow(WindowConfig{Size: 3}, 0)
+	for range 3 {
+		w.Observe(0, ScoreOutcome{Weight: 1})
+	}
+	for range 3 {
+		w.Observe(0, ScoreOutcome{Success: true, Weight: 1})
+	}
+	if w.score() != maxScore {
+		t.Fatalf("expected the failures to have slid out, got %v", w.score())
+	}
+}
+
+func TestWindow_WeightsOutcomes(t *testing.T) {
+	w := NewWindow(WindowConfig{Size: 2}, 0)
+	w.Observe(0, ScoreOutcome{Success: true, Weight: 0.5})
+	w.Observe(0, ScoreOutcome{Weight: 2})
+	if want := scoreFromRate(0.5 / 2.5); !approx(w.score(), want) {
+		t.Fatalf("got %v, want %v", w.score(), want)
+	}
+}
+
+func TestScoringConfig_NewScorer(t *testing.T) {
+	for model, want := range map[string]string{
+		"":              ScoringAdditive,
+		ScoringAdditive: ScoringAdditive,
+		ScoringBeta:     ScoringBeta,
+		ScoringWindow:   ScoringWindow,
+	} {
+		s, err := ScoringConfig{Model: model}.NewScorer(6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name() != want {
+			t.Errorf("model %q: got %s, want %s", model, s.Name(), want)
+		}
+	}
+	if _, err := (ScoringConfig{Model: "nope"}).NewScorer(6); err == nil {
+		t.Fatal("expected an error for an unknown model")
+	}
+}
+
+func TestProxy_UsesConfiguredScorer(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8888")
+	p.Scoring = ScoringConfig{Model: ScoringWindow, Window: WindowConfig{Size: 2}}
+	p.Score = 0
+
+	p.recordFailure("test", nil, sourceHealthCheck, 1)
+	p.recordFailure("test", nil, sourceHealthCheck, 1)
+	if p.Score != minScore {
+		t.Fatalf("expected a window of failures to score minScore, got %v", p.Score)
+	}
+	p.recordSuccess(100, sourceHealthCheck, 1)
+	p.recordSuccess(100, sourceHealthCheck, 1)
+	if p.Score != maxScore {
+		t.Fatalf("expected a window of successes to score maxScore, got %v", p.Score)
+	}
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// DefaultSessionTTL is how long a sticky session binding lives without
+// being used when the caller doesn't ask for a specific TTL.
+const DefaultSessionTTL = 30 * time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session binds a client-supplied key to one exit proxy so that repeated
+// allocations for the same key keep the same IP.
+type Session struct {
+	Key       string    `json:"key"`
+	ProxyURL  string    `json:"proxy"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// savedExpiresAt is the ExpiresAt last written to the SessionStore.
+	savedExpiresAt time.Time
+}
+
+// SessionStore persists session bindings so they survive a restart.
+type SessionStore interface {
+	SaveSession(s Session) error
+	DeleteSession(key string) error
+}
+
+// AllocateSession returns the proxy bound to key, allocating and binding
+// a new one (carrying all of tags) when there is no live binding. The
+// returned bool reports a failover: the key was bound to a proxy whose
+// breaker no longer lets traffic through, or that no longer matches tags,
+// and has been moved to a fresh one. Every hit extends the binding by ttl.
+//
+// Thread-safe.
+func (p *Pool) AllocateSession(key string, ttl time.Duration, tags ...Tag) (*Proxy, bool, error) {
+	a, err := p.allocateSession(AllocationRequest{
+		Tags:       tags,
+		SessionKey: key,
+		SessionTTL: ttl,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return a.Proxy, a.Failover, nil
+}
+
+// sessionSaveFraction is the share of its TTL a sticky hit has to move
+// a binding's expiry by before the new expiry is written to the
+// SessionStore. Hits in between only extend it in memory, so after a
+// restart a binding may expire up to that share of its TTL early.
+const sessionSaveFraction = 4
+
+func (p *Pool) allocateSession(req AllocationRequest) (*Allocation, error) {
+	key, ttl := req.SessionKey, req.SessionTTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	a, save, err := p.bindSession(&req, key, ttl)
+	if save {
+		p.syncSession(key)
+	}
+	return a, err
+}
+
+// bindSession serves req from the binding for key, or binds key to a
+// newly allocated proxy. save reports that the binding has to be
+// written to the SessionStore, which the caller does once sessionMu is
+// released.
+func (p *Pool) bindSession(req *AllocationRequest, key string, ttl time.Duration) (a *Allocation, save bool, err error) {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+
+	now := time.Now()
+	existing, bound := p.sessions[key]
+	if bound && !now.Before(existing.ExpiresAt) {
+		bound = false
+	}
+
+	if bound {
+		if proxy := p.find(existing.ProxyURL); proxy != nil {
+			ok, err := p.claimSticky(proxy, now, req)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				existing.ExpiresAt = now.Add(ttl)
+				save = existing.ExpiresAt.Sub(existing.savedExpiresAt) >= ttl/sessionSaveFraction
+				return &Allocation{Proxy: proxy}, save, nil
+			}
+		}
+	}
+
+	proxy, err := p.allocate(false, req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s := &Session{
+		Key:       key,
+		ProxyURL:  proxy.URL,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if bound {
+		log.Printf("[session] %s failed over from %s to %s", key, existing.ProxyURL, proxy.URL)
+	}
+
+	if p.sessions == nil {
+		p.sessions = make(map[string]*Session)
+	}
+	p.sessions[key] = s
+
+	return &Allocation{Proxy: proxy, Failover: bound}, true, nil
+}
+
+// Sessions lists the live session bindings ordered by key.
+func (p *Pool) Sessions() []Session {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+
+	now := time.Now()
+	out := make([]Session, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		if now.Before(s.ExpiresAt) {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// DeleteSession drops the binding for key so the next allocation with it
+// gets a fresh proxy.
+func (p *Pool) DeleteSession(key string) error {
+	p.sessionMu.Lock()
+	_, ok := p.sessions[key]
+	delete(p.sessions, key)
+	p.sessionMu.Unlock()
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+	p.syncSession(key)
+	return nil
+}
+
+// ExpireSessions drops bindings that have not been used within their TTL
+// and returns how many were removed.
+func (p *Pool) ExpireSessions() int {
+	p.sessionMu.Lock()
+	now := time.Now()
+	var expired []string
+	for key, s := range p.sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(p.sessions, key)
+			expired = append(expired, key)
+		}
+	}
+	p.sessionMu.Unlock()
+
+	for _, key := range expired {
+		p.syncSession(key)
+	}
+	return len(expired)
+}
+
+// RestoreSessions loads previously persisted bindings, skipping any that
+// expired while the service was down.
+func (p *Pool) RestoreSessions(sessions []Session) {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+
+	if p.sessions == nil {
+		p.sessions = make(map[string]*Session)
+	}
+
+	now := time.Now()
+	for _, s := range sessions {
+		if now.Before(s.ExpiresAt) {
+			s.savedExpiresAt = s.ExpiresAt
+			p.sessions[s.Key] = &s
+		}
+	}
+}
+
+// syncSession writes the current binding for key to the SessionStore,
+// or deletes it there when key is no longer bound. It must be called
+// without sessionMu held, so sticky allocations never wait for the
+// store. Syncs are serialized and each one reads the binding afresh, so
+// the store always ends up with the latest state whatever order the
+// callers get here in.
+func (p *Pool) syncSession(key string) {
+	if p.SessionStore == nil {
+		return
+	}
+	p.sessionSyncMu.Lock()
+	defer p.sessionSyncMu.Unlock()
+
+	p.sessionMu.Lock()
+	s, ok := p.sessions[key]
+	var snap Session
+	if ok {
+		snap = *s
+	}
+	p.sessionMu.Unlock()
+
+	if !ok {
+		if err := p.SessionStore.DeleteSession(key); err != nil {
+			log.Printf("[warn] failed to delete session %s: %v", key, err)
+		}
+		return
+	}
+	if err := p.SessionStore.SaveSession(snap); err != nil {
+		log.Printf("[warn] failed to persist session %s: %v", key, err)
+		return
+	}
+	p.sessionMu.Lock()
+	if p.sessions[key] == s {
+		s.savedExpiresAt = snap.ExpiresAt
+	}
+	p.sessionMu.Unlock()
+}
+
+// claimSticky bumps UsageCount and reports true when the proxy can still
+// serve its sticky session under req. A bound proxy that is out of rate
+// limit budget keeps its session; the caller gets a RateLimitError to
+// retry later.
+func (p *Pool) claimSticky(proxy *Proxy, now time.Time, req *AllocationRequest) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if !req.matches(proxy) || proxy.expired(now) || !proxy.admits(now) {
+		return false, nil
+	}
+	if wait := p.throttled(proxy, now); wait > 0 {
+		return false, &RateLimitError{RetryAfter: wait}
+	}
+	proxy.UsageCount++
+	proxy.scoreChanged()
+	p.touch()
+	proxy.admit()
+	p.consumeToken(proxy, now)
+	p.noteAllocated(proxy, now)
+	return true, nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// snapshotMaxAge bounds how stale a published snapshot may get without
+// any change to the pool: breaker cool-downs expire with time alone.
+const snapshotMaxAge = time.Second
+
+// snapshotMinInterval is how long readers keep being served a snapshot
+// after it was published, however busy the pool. Allocations change it
+// all the time; republishing for each of them would keep a rebuild
+// running for every poll.
+const snapshotMinInterval = 250 * time.Millisecond
+
+// PoolSnapshot is an immutable view of the pool published for readers.
+// Version increases with every publish. The slices are shared by every
+// reader of the same snapshot and must not be modified.
+type PoolSnapshot struct {
+	Version uint64
+	At      time.Time
+	Stats   []ProxyStats
+	Alive   []*Proxy
+	Subnets []SubnetStats
+
+	// changes is the pool's change counter at publish time.
+	changes uint64
+}
+
+// snapshotState is the copy-on-write publication machinery embedded in
+// Pool. Readers only ever load current; publishers build a new snapshot
+// under the pool locks and swap it in.
+type snapshotState struct {
+	current    atomic.Pointer[PoolSnapshot]
+	changes    atomic.Uint64
+	refreshing atomic.Bool
+	publishMu  sync.Mutex
+	version    uint64
+}
+
+// Snapshot returns the latest published view of the pool without taking
+// any pool or proxy lock. When the pool has changed since and the view
+// is at least snapshotMinInterval old, or it is older than
+// snapshotMaxAge, a fresh one is published in the background and
+// returned to later callers. Only the very first call publishes
+// synchronously.
+func (p *Pool) Snapshot() *PoolSnapshot {
+	snap := p.snapshots.current.Load()
+	if snap == nil {
+		return p.Publish()
+	}
+	age := time.Since(snap.At)
+	changed := p.snapshots.changes.Load() != snap.changes
+	if (changed && age >= snapshotMinInterval) || age > snapshotMaxAge {
+		p.refreshSnapshot()
+	}
+	return snap
+}
+
+// Snapshots returns the per-proxy stats of the latest snapshot.
+func (p *Pool) Snapshots() []ProxyStats {
+	return p.Snapshot().Stats
+}
+
+// SubnetStats returns the per-subnet diversity metrics of the latest
+// snapshot.
+func (p *Pool) SubnetStats() []SubnetStats {
+	return p.Snapshot().Subnets
+}
+
+// AliveProxies returns the proxies whose breaker was not open as of the
+// latest snapshot, leaving out those on probation.
+func (p *Pool) AliveProxies() []*Proxy {
+	return p.Snapshot().Alive
+}
+
+// Publish builds a snapshot from the live state and makes it current.
+// It runs on the writer side: after every health check, and in the
+// background when readers find the current snapshot stale. It never
+// holds the pool lock for more than a copy, and only one proxy lock at
+// a time, so allocation keeps going while it runs.
+func (p *Pool) Publish() *PoolSnapshot {
+	p.snapshots.publishMu.Lock()
+	defer p.snapshots.publishMu.Unlock()
+
+	changes := p.snapshots.changes.Load()
+
+	members := p.All()
+	now := time.Now()
+	stats := make([]ProxyStats, len(members))
+	alive := make([]*Proxy, 0, len(members))
+	for i, pr := range members {
+		pr.mu.Lock()
+		stats[i] = p.proxyStats(pr, now)
+		pr.mu.Unlock()
+		if stats[i].Alive && !stats[i].Probation {
+			alive = append(alive, pr)
+		}
+	}
+
+	p.mu.Lock()
+	lastUsed := maps.Clone(p.diversity.lastUsed)
+	p.mu.Unlock()
+	subnets := subnetStats(stats, lastUsed)
+
+	p.snapshots.version++
+	snap := &PoolSnapshot{
+		Version: p.snapshots.version,
+		At:      now,
+		Stats:   stats,
+		Alive:   alive,
+		Subnets: subnets,
+		changes: changes,
+	}
+	p.snapshots.current.Store(snap)
+	return snap
+}
+
+// Stats returns the live stats of one proxy.
+func (p *Pool) Stats(proxy *Proxy) ProxyStats {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	return p.proxyStats(proxy, time.Now())
+}
+
+// proxyStats must be called with proxy.mu held.
+func (p *Pool) proxyStats(proxy *Proxy, now time.Time) ProxyStats {
+	proxy.age(now)
+	return ProxyStats{
+		ID:              proxy.ID(),
+		URL:             proxy.URL,
+		IP:              addrString(proxy.Addr()),
+		Subnet:          p.subnet(proxy),
+		ASN:             ParseASN(proxy.Tags[ASNTag]),
+		Alive:           proxy.alive(now),
+		State:           proxy.State.String(),
+		StateChanged:    proxy.StateChangedAt.Format(time.RFC3339),
+		LastTest:        proxy.LastTest.Format(time.RFC3339),
+		NextCheck:       proxy.NextCheck.Format(time.RFC3339),
+		Score:           proxy.Score,
+		UsageCount:      proxy.UsageCount,
+		ActiveLeases:    proxy.ActiveLeases,
+		MaxConcurrent:   p.maxConcurrent(proxy),
+		Tier:            proxy.Tier,
+		ExpiresAt:       formatExpiry(proxy.ExpiresAt),
+		Expiring:        p.expiring(proxy, now),
+		Probation:       proxy.OnProbation,
+		ProbationPasses: proxy.ProbationPasses,
+		FailCount:       proxy.FailCount,
+		SuccessCount:    proxy.SuccessCount,
+		LatencyMS:       proxy.LatencyMS,
+		Latency:         proxy.latency.stats(),
+		Tags:            maps.Clone(proxy.Tags),
+	}
+}
+
+// refreshSnapshot publishes in the background unless a refresh is
+// already running.
+func (p *Pool) refreshSnapshot() {
+	if !p.snapshots.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer p.snapshots.refreshing.Store(false)
+		p.Publish()
+	}()
+}
+
+// touch records that the pool changed so readers trigger a new snapshot.
+func (p *Pool) touch() {
+	p.snapshots.changes.Add(1)
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshot_DoesNotBlockOnWriters(t *testing.T) {
+	pool := newTestPool()
+	first := pool.Snapshot()
+
+	// Hold every lock a writer could hold.
+	pool.mu.Lock()
+	for _, p := range pool.Proxies {
+		p.mu.Lock()
+	}
+	defer func() {
+		for _, p := range pool.Proxies {
+			p.mu.Unlock()
+		}
+		pool.mu.Unlock()
+	}()
+
+	pool.touch()
+	done := make(chan *PoolSnapshot)
+	go func() {
+		pool.Snapshots()
+		pool.AliveProxies()
+		done <- pool.Snapshot()
+	}()
+
+	select {
+	case snap := <-done:
+		if snap != first {
+			t.Fatal("expected the already published snapshot while writers hold the locks")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Snapshot blocked on pool locks")
+	}
+}
+
+func TestSnapshot_RepublishesAfterChange(t *testing.T) {
+	pool := newTestPool()
+	first := pool.Snapshot()
+	if first.Version == 0 || len(first.Stats) != 2 || len(first.Alive) != 2 {
+		t.Fatalf("unexpected first snapshot: %+v", first)
+	}
+
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first read after a change still gets the old view and kicks off
+	// a background publish.
+	if pool.Snapshot() != first {
+		t.Fatal("expected the stale snapshot to be served while refreshing")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		snap := pool.Snapshot()
+		if snap.Version > first.Version {
+			used := 0
+			for _, s := range snap.Stats {
+				used += s.UsageCount
+			}
+			if used != 1 {
+				t.Fatalf("expected the allocation in the new snapshot, got usage %d", used)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot was never republished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPublish_VersionIncreases(t *testing.T) {
+	pool := newTestPool()
+	prev := uint64(0)
+	for range 5 {
+		snap := pool.Publish()
+		if snap.Version <= prev {
+			t.Fatalf("version went from %d to %d", prev, snap.Version)
+		}
+		prev = snap.Version
+	}
+
+	tripBreaker(pool.Proxies[0])
+	if snap := pool.Publish(); len(snap.Alive) != 1 || snap.Stats[0].Alive {
+		t.Fatalf("expected the tripped proxy to be dead in the snapshot: %+v", snap.Stats)
+	}
+}
+
+func TestSnapshot_ConcurrentReadersAndWriters(t *testing.T) {
+	pool := newTestPool()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if lease, err := pool.Acquire(time.Minute); err == nil {
+					_ = pool.ReleaseWithOutcome(lease.ID, Outcome{Success: true})
+				}
+			}
+		}
+	}()
+
+	var last uint64
+	for range 2000 {
+		snap := pool.Snapshot()
+		if snap.Version < last {
+			t.Fatalf("version went backwards: %d -> %d", last, snap.Version)
+		}
+		last = snap.Version
+		for _, s := range snap.Stats {
+			_ = s.Score
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestPublish_TakesOneProxyLockAtATime(t *testing.T) {
+	pool := newTestPool()
+	busy := pool.Proxies[1]
+	busy.mu.Lock()
+
+	published := make(chan struct{})
+	go func() {
+		pool.Publish()
+		close(published)
+	}()
+
+	// Publish waits for the busy proxy without holding the pool lock, so
+	// allocation can still take it and the other proxy.
+	locked := make(chan struct{})
+	go func() {
+		pool.mu.Lock()
+		first := pool.Proxies[0]
+		first.mu.Lock()
+		first.mu.Unlock()
+		pool.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Publish held the pool lock while waiting for a proxy")
+	}
+
+	busy.mu.Unlock()
+	<-published
+}
+
+func TestSnapshot_MinInterval(t *testing.T) {
+	pool := newTestPool()
+	first := pool.Publish()
+
+	for range 10 {
+		pool.touch()
+		if pool.Snapshot() != first {
+			t.Fatal("expected the fresh snapshot to be served")
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if snap := pool.Snapshot(); snap != first || pool.snapshots.refreshing.Load() {
+		t.Fatal("expected no republish within the minimum interval")
+	}
+}
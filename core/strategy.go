@@ -0,0 +1,212 @@
+package core
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Candidate is a point-in-time view of an allocatable proxy.
+// Strategies only ever see candidates, never the live proxy state,
+// so they don't need to care about locking.
+type Candidate struct {
+	Proxy      *Proxy
+	Score      float64
+	UsageCount int
+	InUse      int
+	LatencyMS  int
+	// SuccessCount and FailCount feed the bandit strategies: the
+	// proxy's latest banditHorizon outcomes, see Proxy.banditCounts.
+	SuccessCount int
+	FailCount    int
+}
+
+// AllocationStrategy decides which of the alive candidates is handed out.
+// Select receives a non-empty slice and returns the index of the chosen
+// candidate. Implementations must be safe for concurrent use.
+type AllocationStrategy interface {
+	Name() string
+	Select(candidates []Candidate) int
+}
+
+const (
+	StrategyBestScore      = "best-score"
+	StrategyRoundRobin     = "round-robin"
+	StrategyWeightedRandom = "weighted-random"
+	StrategyLeastLatency   = "least-latency"
+	StrategyLeastInUse     = "least-in-use"
+	StrategyPowerOfTwo     = "power-of-two"
+)
+
+// NewStrategy returns the built-in strategy registered under name.
+// An empty name selects the default best-score strategy.
+func NewStrategy(name string) (AllocationStrategy, error) {
+	return NewStrategyWithOptions(name, StrategyOptions{})
+}
+
+// NewStrategyWithOptions is NewStrategy for strategies that take
+// parameters; the others ignore opts.
+func NewStrategyWithOptions(name string, opts StrategyOptions) (AllocationStrategy, error) {
+	switch name {
+	case "", StrategyBestScore:
+		return BestScore{}, nil
+	case StrategyRoundRobin:
+		return &RoundRobin{}, nil
+	case StrategyWeightedRandom:
+		return WeightedRandom{}, nil
+	case StrategyLeastLatency:
+		return LeastLatency{}, nil
+	case StrategyLeastInUse:
+		return LeastInUse{}, nil
+	case StrategyPowerOfTwo:
+		return PowerOfTwo{}, nil
+	case StrategyThompson:
+		return Thompson{Options: opts}, nil
+	case StrategyUCB1:
+		return UCB1{Options: opts}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation strategy %q", name)
+	}
+}
+
+// better reports whether a ranks above b under the classic rules:
+// higher score first, lower usage on a tie.
+func better(a, b Candidate) bool {
+	if a.Score == b.Score {
+		return a.UsageCount < b.UsageCount
+	}
+	return a.Score > b.Score
+}
+
+// BestScore always picks the highest scored proxy, preferring the
+// less used one on a tie. This is the historical behaviour of Allocate.
+type BestScore struct{}
+
+func (BestScore) Name() string { return StrategyBestScore }
+
+func (BestScore) Select(candidates []Candidate) int {
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		if better(candidates[i], candidates[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// RoundRobin cycles through the candidates regardless of score.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (*RoundRobin) Name() string { return StrategyRoundRobin }
+
+func (r *RoundRobin) Select(candidates []Candidate) int {
+	n := r.next.Add(1) - 1
+	return int(n % uint64(len(candidates)))
+}
+
+// WeightedRandom picks a candidate at random with probability
+// proportional to its score, shifted so the lowest possible score
+// still has a small chance of being picked.
+type WeightedRandom struct{}
+
+func (WeightedRandom) Name() string { return StrategyWeightedRandom }
+
+func (WeightedRandom) Select(candidates []Candidate) int {
+	const floor = 0.1
+
+	total := 0.0
+	for _, c := range candidates {
+		total += scoreWeight(c.Score, floor)
+	}
+
+	r := rand.Float64() * total
+	for i, c := range candidates {
+		r -= scoreWeight(c.Score, floor)
+		if r < 0 {
+			return i
+		}
+	}
+	return len(candidates) - 1
+}
+
+func scoreWeight(score, floor float64) float64 {
+	w := score - minScore
+	if w < floor {
+		w = floor
+	}
+	return w
+}
+
+// LeastLatency picks the proxy with the lowest smoothed latency.
+// Proxies without a measurement yet rank after measured ones.
+type LeastLatency struct{}
+
+func (LeastLatency) Name() string { return StrategyLeastLatency }
+
+func (LeastLatency) Select(candidates []Candidate) int {
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		a, b := candidates[i], candidates[best]
+		switch {
+		case a.LatencyMS == b.LatencyMS:
+			if better(a, b) {
+				best = i
+			}
+		case b.LatencyMS == 0:
+			best = i
+		case a.LatencyMS != 0 && a.LatencyMS < b.LatencyMS:
+			best = i
+		}
+	}
+	return best
+}
+
+// LeastInUse picks the proxy with the fewest active leases, then the
+// one handed out the least overall, using score as the final tie-breaker.
+type LeastInUse struct{}
+
+func (LeastInUse) Name() string { return StrategyLeastInUse }
+
+func (LeastInUse) Select(candidates []Candidate) int {
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		a, b := candidates[i], candidates[best]
+		switch {
+		case a.InUse != b.InUse:
+			if a.InUse < b.InUse {
+				best = i
+			}
+		case a.UsageCount != b.UsageCount:
+			if a.UsageCount < b.UsageCount {
+				best = i
+			}
+		case a.Score > b.Score:
+			best = i
+		}
+	}
+	return best
+}
+
+// PowerOfTwo samples two candidates at random and keeps the better one.
+// It spreads load much more evenly than BestScore while still steering
+// away from bad proxies.
+type PowerOfTwo struct{}
+
+func (PowerOfTwo) Name() string { return StrategyPowerOfTwo }
+
+func (PowerOfTwo) Select(candidates []Candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if better(candidates[j], candidates[i]) {
+		return j
+	}
+	return i
+}
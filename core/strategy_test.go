@@ -0,0 +1,124 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestNewStrategy_KnownNames(t *testing.T) {
+	names := []string{
+		"",
+		StrategyBestScore,
+		StrategyRoundRobin,
+		StrategyWeightedRandom,
+		StrategyLeastLatency,
+		StrategyLeastInUse,
+		StrategyPowerOfTwo,
+		StrategyThompson,
+		StrategyUCB1,
+	}
+	for _, name := range names {
+		s, err := NewStrategy(name)
+		if err != nil {
+			t.Fatalf("NewStrategy(%q): %v", name, err)
+		}
+		if name != "" && s.Name() != name {
+			t.Fatalf("expected strategy %q, got %q", name, s.Name())
+		}
+	}
+
+	if _, err := NewStrategy("fastest-ever"); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
+
+func TestRoundRobin_Cycles(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{
+			{URL: "A", Score: 10},
+			{URL: "B", Score: 1},
+			{URL: "C", Score: 1},
+		},
+		Strategy: &RoundRobin{},
+	}
+
+	var got []string
+	for range 6 {
+		p, err := pool.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.URL)
+	}
+
+	want := []string{"A", "B", "C", "A", "B", "C"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestWeightedRandom_FavoursHigherScore(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{
+			{URL: "good", Score: 10},
+			{URL: "bad", Score: -5},
+		},
+		Strategy: WeightedRandom{},
+	}
+
+	counts := map[string]int{}
+	for range 1000 {
+		p, err := pool.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[p.URL]++
+	}
+
+	if counts["good"] < 900 {
+		t.Fatalf("expected good proxy to dominate, got %+v", counts)
+	}
+	if counts["bad"] == 0 {
+		t.Fatalf("expected bad proxy to be picked occasionally, got %+v", counts)
+	}
+}
+
+func TestLeastLatency_SkipsUnmeasured(t *testing.T) {
+	candidates := []Candidate{
+		{Proxy: &Proxy{URL: "unmeasured"}, LatencyMS: 0, Score: 10},
+		{Proxy: &Proxy{URL: "slow"}, LatencyMS: 800, Score: 10},
+		{Proxy: &Proxy{URL: "fast"}, LatencyMS: 120, Score: 2},
+	}
+
+	got := candidates[LeastLatency{}.Select(candidates)].Proxy.URL
+	if got != "fast" {
+		t.Fatalf("expected fast proxy, got %s", got)
+	}
+}
+
+func TestLeastInUse_PrefersIdle(t *testing.T) {
+	candidates := []Candidate{
+		{Proxy: &Proxy{URL: "busy"}, UsageCount: 50, Score: 10},
+		{Proxy: &Proxy{URL: "idle"}, UsageCount: 3, Score: 1},
+	}
+
+	got := candidates[LeastInUse{}.Select(candidates)].Proxy.URL
+	if got != "idle" {
+		t.Fatalf("expected idle proxy, got %s", got)
+	}
+}
+
+func TestPowerOfTwo_NeverPicksWorstOfTwo(t *testing.T) {
+	candidates := []Candidate{
+		{Proxy: &Proxy{URL: "good"}, Score: 9},
+		{Proxy: &Proxy{URL: "bad"}, Score: 1},
+	}
+
+	for range 100 {
+		got := candidates[PowerOfTwo{}.Select(candidates)].Proxy.URL
+		if got != "good" {
+			t.Fatalf("expected good proxy, got %s", got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tag is a key/value label attached to a proxy, e.g. country:us or
+// type:residential.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+func (t Tag) String() string {
+	return t.Key + ":" + t.Value
+}
+
+// ParseTag parses the "key:value" form used in query strings. The value
+// may itself contain colons; only the first one separates the key.
+func ParseTag(s string) (Tag, error) {
+	key, value, ok := strings.Cut(s, ":")
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if !ok || key == "" || value == "" {
+		return Tag{}, fmt.Errorf("invalid tag %q, expected key:value", s)
+	}
+	return Tag{Key: key, Value: value}, nil
+}
+
+// hasTags reports whether the proxy carries every one of tags.
+// Must be called with p.mu held.
+func (p *Proxy) hasTags(tags []Tag) bool {
+	for _, t := range tags {
+		if p.Tags[t.Key] != t.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	tag, err := ParseTag("region:eu:west")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Key != "region" || tag.Value != "eu:west" {
+		t.Fatalf("unexpected tag %+v", tag)
+	}
+
+	for _, bad := range []string{"", "country", ":us", "country:"} {
+		if _, err := ParseTag(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestAllocate_FiltersByTags(t *testing.T) {
+	pool := &Pool{
+		Proxies: []*Proxy{
+			{URL: "dc-us", Score: 10, Tags: map[string]string{"country": "us", "type": "datacenter"}},
+			{URL: "res-us", Score: 5, Tags: map[string]string{"country": "us", "type": "residential"}},
+			{URL: "res-de", Score: 8, Tags: map[string]string{"country": "de", "type": "residential"}},
+		},
+	}
+
+	p, err := pool.Allocate(Tag{"country", "us"}, Tag{"type", "residential"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.URL != "res-us" {
+		t.Fatalf("expected res-us, got %s", p.URL)
+	}
+
+	if _, err := pool.Allocate(Tag{"country", "fr"}); err == nil {
+		t.Fatal("expected error when no proxy carries the tag")
+	}
+
+	p, err = pool.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.URL != "dc-us" {
+		t.Fatalf("expected untagged request to use the whole pool, got %s", p.URL)
+	}
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// anyTier scopes a scan to every tier.
+const anyTier = -1
+
+const (
+	// recentOutcomesSize is how many of a proxy's latest outcomes (checks
+	// and client reports) feed its tier's success rate.
+	recentOutcomesSize = 20
+	// maxTierEvents bounds the failover history kept per pool.
+	maxTierEvents = 50
+
+	defaultTierMinAlive = 1
+)
+
+// Tier event kinds.
+const (
+	TierFailover = "failover"
+	TierFailback = "failback"
+)
+
+// TierConfig sets when a tier counts as degraded. Proxies carry a Tier
+// (0, the default, is the highest priority); allocation serves from the
+// highest-priority healthy tier and only falls through to lower ones
+// when it is degraded or has no capacity left.
+type TierConfig struct {
+	// MinAlive is the number of allocatable proxies a tier needs to stay
+	// healthy. Zero means 1.
+	MinAlive int `yaml:"min_alive"`
+	// MinSuccessRate is the success rate (0..1) over the recent outcomes
+	// of a tier's proxies below which it is degraded. Zero disables the
+	// check.
+	MinSuccessRate float64 `yaml:"min_success_rate"`
+}
+
+func (c TierConfig) minAlive() int {
+	if c.MinAlive <= 0 {
+		return defaultTierMinAlive
+	}
+	return c.MinAlive
+}
+
+// TierStatus is the health of one tier as of the last evaluation.
+type TierStatus struct {
+	Tier        int     `json:"tier"`
+	Total       int     `json:"total"`
+	Alive       int     `json:"alive"`
+	SuccessRate float64 `json:"success_rate"`
+	Healthy     bool    `json:"healthy"`
+	// Active marks the tier allocation currently serves from.
+	Active bool `json:"active"`
+}
+
+// TierEvent records allocation moving to another tier: a failover to a
+// lower-priority tier or a failback to a higher-priority one.
+type TierEvent struct {
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`
+	From   int       `json:"from"`
+	To     int       `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// tierState is the result of the last tier evaluation, guarded by the
+// pool mutex. Before the first evaluation every tier counts as healthy.
+type tierState struct {
+	evaluated bool
+	status    []TierStatus
+	active    int
+	events    []TierEvent
+}
+
+// outcomeWindow is a ring of a proxy's latest size outcomes.
+type outcomeWindow struct {
+	size    int
+	results []bool
+	next    int
+}
+
+func (w *outcomeWindow) add(success bool) {
+	if len(w.results) < w.size {
+		w.results = append(w.results, success)
+		return
+	}
+	w.results[w.next] = success
+	w.next = (w.next + 1) % w.size
+}
+
+// counts returns the successes and total of the window; nil-safe.
+func (w *outcomeWindow) counts() (successes, total int) {
+	if w == nil {
+		return 0, 0
+	}
+	for _, ok := range w.results {
+		if ok {
+			successes++
+		}
+	}
+	return successes, len(w.results)
+}
+
+// recordOutcome must be called with p.mu held.
+func (p *Proxy) recordOutcome(success bool) {
+	if p.recent == nil {
+		p.recent = &outcomeWindow{size: recentOutcomesSize}
+		p.banditWindow = &outcomeWindow{size: banditHorizon}
+	}
+	p.recent.add(success)
+	p.banditWindow.add(success)
+}
+
+// UpdateTiers re-evaluates the health of every tier and moves
+// allocation to the highest-priority healthy one. It returns the
+// failover or failback event when the active tier changed, and is
+// meant to run after each round of health checks.
+func (p *Pool) UpdateTiers() []TierEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	byTier := map[int]*TierStatus{}
+	successes := map[int]int{}
+	outcomes := map[int]int{}
+	for _, proxy := range p.Proxies {
+		proxy.mu.Lock()
+		st, ok := byTier[proxy.Tier]
+		if !ok {
+			st = &TierStatus{Tier: proxy.Tier}
+			byTier[proxy.Tier] = st
+		}
+		st.Total++
+		if !proxy.OnProbation && !proxy.expired(now) && proxy.alive(now) {
+			st.Alive++
+			s, n := proxy.recent.counts()
+			successes[proxy.Tier] += s
+			outcomes[proxy.Tier] += n
+		}
+		proxy.mu.Unlock()
+	}
+
+	status := make([]TierStatus, 0, len(byTier))
+	for tier, st := range byTier {
+		if outcomes[tier] > 0 {
+			st.SuccessRate = float64(successes[tier]) / float64(outcomes[tier])
+		} else if st.Alive > 0 {
+			st.SuccessRate = 1
+		}
+		st.Healthy = st.Alive >= p.Tiering.minAlive() && st.SuccessRate >= p.Tiering.MinSuccessRate
+		status = append(status, *st)
+	}
+	slices.SortFunc(status, func(a, b TierStatus) int { return a.Tier - b.Tier })
+
+	active := -1
+	for i := range status {
+		if status[i].Healthy {
+			active = status[i].Tier
+			break
+		}
+	}
+	if active < 0 && len(status) > 0 {
+		active = status[0].Tier
+	}
+	for i := range status {
+		status[i].Active = status[i].Tier == active
+	}
+
+	prev := p.tiers.active
+	wasEvaluated := p.tiers.evaluated
+	p.tiers.evaluated = true
+	p.tiers.status = status
+	p.tiers.active = active
+	p.touch()
+
+	if !wasEvaluated || active == prev || len(status) < 2 {
+		return nil
+	}
+
+	ev := TierEvent{At: now, Kind: TierFailback, From: prev, To: active}
+	if active > prev {
+		ev.Kind = TierFailover
+	}
+	ev.Reason = tierReason(status, prev, active)
+	p.tiers.events = append(p.tiers.events, ev)
+	if n := len(p.tiers.events); n > maxTierEvents {
+		p.tiers.events = slices.Clone(p.tiers.events[n-maxTierEvents:])
+	}
+	return []TierEvent{ev}
+}
+
+// tierReason explains a move from tier from to tier to.
+func tierReason(status []TierStatus, from, to int) string {
+	for _, st := range status {
+		if st.Tier != from {
+			continue
+		}
+		if to > from {
+			return fmt.Sprintf("tier %d degraded: %d alive, success rate %.2f", from, st.Alive, st.SuccessRate)
+		}
+	}
+	for _, st := range status {
+		if st.Tier == to {
+			return fmt.Sprintf("tier %d recovered: %d alive, success rate %.2f", to, st.Alive, st.SuccessRate)
+		}
+	}
+	return fmt.Sprintf("tier %d removed", from)
+}
+
+// Tiers returns the tier health as of the last UpdateTiers, ordered by
+// priority.
+func (p *Pool) Tiers() []TierStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append(make([]TierStatus, 0, len(p.tiers.status)), p.tiers.status...)
+}
+
+// TierEvents returns the most recent failover and failback events,
+// oldest first.
+func (p *Pool) TierEvents() []TierEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append(make([]TierEvent, 0, len(p.tiers.events)), p.tiers.events...)
+}
+
+// tierOrder returns the tiers to try for an allocation: healthy ones by
+// priority, then degraded ones by priority. It returns nil when the pool
+// has a single tier. Must be called with p.mu held.
+func (p *Pool) tierOrder() []int {
+	if p.tierList == nil {
+		p.indexTiers()
+	}
+	if len(p.tierList) < 2 {
+		return nil
+	}
+	if !p.tiers.evaluated || len(p.tiers.status) == 0 {
+		return p.tierList
+	}
+
+	healthy := make(map[int]bool, len(p.tiers.status))
+	for _, st := range p.tiers.status {
+		healthy[st.Tier] = st.Healthy
+	}
+	order := make([]int, 0, len(p.tierList))
+	for _, t := range p.tierList {
+		if healthy[t] {
+			order = append(order, t)
+		}
+	}
+	for _, t := range p.tierList {
+		if !healthy[t] {
+			order = append(order, t)
+		}
+	}
+	return order
+}
+
+// indexTiers caches the distinct tiers of Proxies for tierOrder. Must be
+// called with p.mu held.
+func (p *Pool) indexTiers() {
+	seen := map[int]bool{}
+	list := make([]int, 0, 2)
+	for _, proxy := range p.Proxies {
+		proxy.mu.Lock()
+		t := proxy.Tier
+		proxy.mu.Unlock()
+		if !seen[t] {
+			seen[t] = true
+			list = append(list, t)
+		}
+	}
+	slices.Sort(list)
+	p.tierList = list
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTieredPool returns a pool with one proxy in tier 0 and one in tier
+// 1. The backup has the better score so best-score alone would pick it.
+func newTieredPool() (pool *Pool, primary, backup *Proxy) {
+	primary = newTestProxy("http://127.0.0.1:8888")
+	backup = newTestProxy("http://127.0.0.1:8889")
+	backup.Tier = 1
+	backup.Score = 10
+	return &Pool{Proxies: []*Proxy{primary, backup}}, primary, backup
+}
+
+func TestTiers_ServesPrimaryFirst(t *testing.T) {
+	pool, primary, _ := newTieredPool()
+
+	for range 20 {
+		got, err := pool.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != primary {
+			t.Fatalf("expected the tier 0 proxy, got %s", got.URL)
+		}
+	}
+}
+
+func TestTiers_FallsThroughWhenPrimaryHasNoCapacity(t *testing.T) {
+	pool, primary, backup := newTieredPool()
+	primary.MaxConcurrent = 1
+
+	first, err := pool.Acquire(time.Minute)
+	if err != nil || first.Proxy != primary {
+		t.Fatalf("expected the first lease on the primary, got %v, %v", first, err)
+	}
+	second, err := pool.Acquire(time.Minute)
+	if err != nil || second.Proxy != backup {
+		t.Fatalf("expected the second lease on the backup, got %v, %v", second, err)
+	}
+
+	if err := pool.Release(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := pool.Allocate(); err != nil || got != primary {
+		t.Fatalf("expected the primary once it has capacity again, got %v, %v", got, err)
+	}
+}
+
+func TestTiers_ErrorsCoverEveryTier(t *testing.T) {
+	pool, primary, backup := newTieredPool()
+	tripBreaker(primary)
+	tripBreaker(backup)
+
+	if _, err := pool.Allocate(); !errors.Is(err, ErrNoAliveProxies) {
+		t.Fatalf("expected ErrNoAliveProxies, got %v", err)
+	}
+	if _, err := pool.Allocate(Tag{Key: "country", Value: "de"}); !errors.Is(err, ErrNoMatch) {
+		t.Fatalf("expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestUpdateTiers_FailoverAndFailback(t *testing.T) {
+	pool, primary, backup := newTieredPool()
+	pool.Tiering = TierConfig{MinSuccessRate: 0.5}
+
+	if events := pool.UpdateTiers(); len(events) != 0 {
+		t.Fatalf("expected no event on the first evaluation, got %+v", events)
+	}
+
+	// The primary keeps passing admission but most requests through it
+	// fail, so its tier degrades.
+	for range 10 {
+		primary.recordOutcome(false)
+	}
+	events := pool.UpdateTiers()
+	if len(events) != 1 || events[0].Kind != TierFailover || events[0].From != 0 || events[0].To != 1 {
+		t.Fatalf("expected a failover from 0 to 1, got %+v", events)
+	}
+	if got, err := pool.Allocate(); err != nil || got != backup {
+		t.Fatalf("expected the backup after failover, got %v, %v", got, err)
+	}
+
+	status := pool.Tiers()
+	if len(status) != 2 || status[0].Healthy || !status[1].Active || status[0].SuccessRate != 0 {
+		t.Fatalf("unexpected tier status: %+v", status)
+	}
+
+	for range recentOutcomesSize {
+		primary.recordOutcome(true)
+	}
+	events = pool.UpdateTiers()
+	if len(events) != 1 || events[0].Kind != TierFailback || events[0].To != 0 {
+		t.Fatalf("expected a failback to 0, got %+v", events)
+	}
+	if got, err := pool.Allocate(); err != nil || got != primary {
+		t.Fatalf("expected the primary after failback, got %v, %v", got, err)
+	}
+	if history := pool.TierEvents(); len(history) != 2 {
+		t.Fatalf("expected both events in the history, got %+v", history)
+	}
+}
+
+func TestUpdateTiers_MinAlive(t *testing.T) {
+	pool, _, _ := newTieredPool()
+	second := newTestProxy("http://127.0.0.1:8890")
+	backup2 := newTestProxy("http://127.0.0.1:8891")
+	backup2.Tier = 1
+	pool.Proxies = append(pool.Proxies, second, backup2)
+	pool.Tiering = TierConfig{MinAlive: 2}
+	pool.UpdateTiers()
+
+	tripBreaker(second)
+	events := pool.UpdateTiers()
+	if len(events) != 1 || events[0].Kind != TierFailover {
+		t.Fatalf("expected a failover when tier 0 drops below min_alive, got %+v", events)
+	}
+	if status := pool.Tiers(); status[0].Alive != 1 || status[0].Total != 2 {
+		t.Fatalf("unexpected tier 0 status: %+v", status[0])
+	}
+}
+
+func TestTiers_UseIndex(t *testing.T) {
+	pool := newTestPool()
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.tierOrder() != nil {
+		t.Fatalf("expected no tier order for a single-tier pool, got %v", pool.tierOrder())
+	}
+	if pool.index == nil {
+		t.Fatal("expected the single-tier pool to allocate through the index")
+	}
+
+	tier := 1
+	if _, err := pool.Update(pool.Proxies[1].ID(), ProxyUpdate{Tier: &tier}); err != nil {
+		t.Fatal(err)
+	}
+	if got := pool.tierOrder(); len(got) != 2 {
+		t.Fatalf("expected the tier change to be picked up, got %v", got)
+	}
+
+	got, err := pool.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != pool.Proxies[0] {
+		t.Fatalf("expected the tier 0 proxy, got %s", got.URL)
+	}
+	if pool.index == nil || len(pool.index.tiers) != 2 {
+		t.Fatalf("expected the tiered pool to allocate through an index per tier, got %+v", pool.index)
+	}
+}
@@ -2,6 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -31,20 +34,21 @@ func ConnectAndMigrate() *Store {
 
 	if firstRun {
 		log.Println("No database found, running migrations...")
-		m, err := migrate.New(
-			"file://migrations",
-			"sqlite3://"+dbPath,
-		)
-		if err != nil {
-			log.Fatal("failed to load migrations:", err)
-		}
-		if err := m.Up(); err != nil && err.Error() != "no change" {
-			log.Fatal("failed to apply migrations:", err)
-		}
-		log.Println("Migrations applied.")
 	} else {
-		log.Println("Database already exists, skipping migrations.")
+		log.Println("Database found, applying pending migrations...")
+	}
+
+	m, err := migrate.New(
+		"file://migrations",
+		"sqlite3://"+dbPath,
+	)
+	if err != nil {
+		log.Fatal("failed to load migrations:", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		log.Fatal("failed to apply migrations:", err)
 	}
+	log.Println("Migrations applied.")
 
 	return &Store{DB: db}
 }
@@ -65,35 +69,137 @@ func (s *Store) GetUserByUsername(username string) (id string, passwordHash stri
 	return
 }
 
-func (s *Store) SaveProxy(p *core.Proxy) error {
-	_, err := s.DB.Exec(`
-		INSERT INTO proxies (url, score, alive, last_test, usage_count, fail_count, success_count, latency_ms)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(url) DO UPDATE SET
+// SaveProxy upserts the proxy and marks it active. It goes through
+// core.Proxy.Persist, so proxies that have been removed from their pool
+// are skipped and a late save can't bring them back.
+func (s *Store) SaveProxy(pool string, p *core.Proxy) error {
+	return p.Persist(func(snap core.ProxySnapshot) error {
+		return s.saveProxy(pool, p, snap)
+	})
+}
+
+func (s *Store) saveProxy(pool string, p *core.Proxy, snap core.ProxySnapshot) error {
+	tags, err := json.Marshal(snap.Tags)
+	if err != nil {
+		return err
+	}
+	rateLimit, err := json.Marshal(snap.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec(`
+		INSERT INTO proxies (pool, url, score, alive, last_test, usage_count, fail_count, success_count, latency_ms,
+			breaker_state, state_changed_at, consecutive_failures, tags, max_concurrent, rate_limit,
+			on_probation, probation_passes, tier, scored_at, expires_at, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'active')
+		ON CONFLICT(pool, url) DO UPDATE SET
 			score = excluded.score,
 			alive = excluded.alive,
 			last_test = excluded.last_test,
 			usage_count = excluded.usage_count,
 			fail_count = excluded.fail_count,
 			success_count = excluded.success_count,
-			latency_ms = excluded.latency_ms
-	`, p.URL, p.Score, p.Alive, p.LastTest, p.UsageCount, p.FailCount, p.SuccessCount, p.LatencyMS)
+			latency_ms = excluded.latency_ms,
+			breaker_state = excluded.breaker_state,
+			state_changed_at = excluded.state_changed_at,
+			consecutive_failures = excluded.consecutive_failures,
+			tags = excluded.tags,
+			max_concurrent = excluded.max_concurrent,
+			rate_limit = excluded.rate_limit,
+			on_probation = excluded.on_probation,
+			probation_passes = excluded.probation_passes,
+			tier = excluded.tier,
+			scored_at = excluded.scored_at,
+			expires_at = excluded.expires_at,
+			status = excluded.status
+	`, pool, snap.URL, snap.Score, snap.Alive, snap.LastTest, snap.UsageCount, snap.FailCount, snap.SuccessCount, snap.LatencyMS,
+		snap.State.String(), snap.StateChangedAt, snap.ConsecutiveFailures, string(tags), snap.MaxConcurrent, string(rateLimit),
+		snap.OnProbation, snap.ProbationPasses, snap.Tier, snap.ScoredAt, nullTime(snap.ExpiresAt))
+	if err != nil {
+		return err
+	}
+
+	for _, ds := range p.Domains() {
+		if err := s.saveDomainScore(pool, p.URL, ds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteProxy removes the proxy and its domain scores.
+func (s *Store) DeleteProxy(pool, proxyURL string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM proxy_domain_scores WHERE pool = ? AND proxy_url = ?", pool, proxyURL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM proxies WHERE pool = ? AND url = ?", pool, proxyURL); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetireProxy marks the proxy expired. Unlike DeleteProxy it keeps the
+// row and its domain scores for their history; LoadProxies skips it
+// until it is saved again, see RetiredProxy.
+func (s *Store) RetireProxy(pool, proxyURL string) error {
+	_, err := s.DB.Exec("UPDATE proxies SET status = 'expired' WHERE pool = ? AND url = ?", pool, proxyURL)
+	return err
+}
+
+func (s *Store) saveDomainScore(pool, proxyURL string, ds core.DomainScore) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO proxy_domain_scores (pool, proxy_url, domain, score, success_count, fail_count, updated_at, aged_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(pool, proxy_url, domain) DO UPDATE SET
+			score = excluded.score,
+			success_count = excluded.success_count,
+			fail_count = excluded.fail_count,
+			updated_at = excluded.updated_at,
+			aged_at = excluded.aged_at
+	`, pool, proxyURL, ds.Domain, ds.Score, ds.SuccessCount, ds.FailCount, ds.UpdatedAt, ds.AgedAt)
 	return err
 }
 
-func (s *Store) SaveAllProxies(proxies []*core.Proxy) {
+func (s *Store) SaveAllProxies(pool string, proxies []*core.Proxy) {
 	for _, p := range proxies {
-		if err := s.SaveProxy(p); err != nil {
+		if err := s.SaveProxy(pool, p); err != nil {
 			println("[warn] failed to save proxy:", p.URL, "err:", err.Error())
 		}
 	}
 }
 
-func (s *Store) LoadProxies() ([]*core.Proxy, error) {
+// LoadProxies returns the active proxies of pool, without the ones
+// retired by RetireProxy.
+func (s *Store) LoadProxies(pool string) ([]*core.Proxy, error) {
+	return s.loadProxies(pool, "status = 'active'")
+}
+
+// RetiredProxy returns the stored state of the proxy retired by
+// RetireProxy, or nil when proxyURL is not a retired proxy of pool.
+func (s *Store) RetiredProxy(pool, proxyURL string) (*core.Proxy, error) {
+	proxies, err := s.loadProxies(pool, "status = 'expired' AND url = ?", proxyURL)
+	if err != nil || len(proxies) == 0 {
+		return nil, err
+	}
+	return proxies[0], nil
+}
+
+// loadProxies returns the proxies of pool whose rows match the SQL
+// condition where.
+func (s *Store) loadProxies(pool, where string, args ...any) ([]*core.Proxy, error) {
 	rows, err := s.DB.Query(`
-		SELECT url, score, alive, last_test, usage_count, fail_count, success_count, latency_ms
+		SELECT url, score, last_test, usage_count, fail_count, success_count, latency_ms,
+			breaker_state, state_changed_at, consecutive_failures, tags, max_concurrent, rate_limit,
+			on_probation, probation_passes, tier, scored_at, expires_at
 		FROM proxies
-	`)
+		WHERE pool = ? AND `+where, append([]any{pool}, args...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,19 +209,164 @@ func (s *Store) LoadProxies() ([]*core.Proxy, error) {
 	for rows.Next() {
 		var p core.Proxy
 		var lastTest time.Time
+		var state, tags, rateLimit string
+		var expiresAt sql.NullTime
 
 		if err := rows.Scan(
-			&p.URL, &p.Score, &p.Alive, &lastTest,
+			&p.URL, &p.Score, &lastTest,
 			&p.UsageCount, &p.FailCount, &p.SuccessCount, &p.LatencyMS,
+			&state, &p.StateChangedAt, &p.ConsecutiveFailures, &tags,
+			&p.MaxConcurrent, &rateLimit,
+			&p.OnProbation, &p.ProbationPasses, &p.Tier, &p.ScoredAt, &expiresAt,
 		); err != nil {
 			return nil, err
 		}
 
+		if err := json.Unmarshal([]byte(tags), &p.Tags); err != nil {
+			return nil, fmt.Errorf("proxy %s: invalid tags: %w", p.URL, err)
+		}
+		if err := json.Unmarshal([]byte(rateLimit), &p.RateLimit); err != nil {
+			return nil, fmt.Errorf("proxy %s: invalid rate_limit: %w", p.URL, err)
+		}
+
 		p.LastTest = lastTest
+		p.ExpiresAt = expiresAt.Time
+		p.State = core.ParseBreakerState(state)
 		p.Timeout = 5 * time.Second
 
 		proxies = append(proxies, &p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := s.loadDomainScores(pool, proxies); err != nil {
+		return nil, err
+	}
 	return proxies, nil
 }
+
+func (s *Store) loadDomainScores(pool string, proxies []*core.Proxy) error {
+	byURL := make(map[string]*core.Proxy, len(proxies))
+	for _, p := range proxies {
+		byURL[p.URL] = p
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT proxy_url, domain, score, success_count, fail_count, updated_at, aged_at
+		FROM proxy_domain_scores
+		WHERE pool = ?
+	`, pool)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var proxyURL string
+		var ds core.DomainScore
+		if err := rows.Scan(&proxyURL, &ds.Domain, &ds.Score, &ds.SuccessCount, &ds.FailCount, &ds.UpdatedAt, &ds.AgedAt); err != nil {
+			return err
+		}
+
+		p, ok := byURL[proxyURL]
+		if !ok {
+			continue
+		}
+		if p.DomainScores == nil {
+			p.DomainScores = make(map[string]core.DomainScore)
+		}
+		p.DomainScores[ds.Domain] = ds
+	}
+	return rows.Err()
+}
+
+// Proxies returns a core.ProxyStore that persists the proxies of one
+// pool.
+func (s *Store) Proxies(pool string) core.ProxyStore {
+	return &proxyStore{store: s, pool: pool}
+}
+
+type proxyStore struct {
+	store *Store
+	pool  string
+}
+
+func (ps *proxyStore) SaveProxy(p *core.Proxy) error {
+	return ps.store.SaveProxy(ps.pool, p)
+}
+
+func (ps *proxyStore) DeleteProxy(proxyURL string) error {
+	return ps.store.DeleteProxy(ps.pool, proxyURL)
+}
+
+func (ps *proxyStore) RetireProxy(proxyURL string) error {
+	return ps.store.RetireProxy(ps.pool, proxyURL)
+}
+
+func (ps *proxyStore) RetiredProxy(proxyURL string) (*core.Proxy, error) {
+	return ps.store.RetiredProxy(ps.pool, proxyURL)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Sessions returns a core.SessionStore that persists the sticky sessions
+// of one pool.
+func (s *Store) Sessions(pool string) core.SessionStore {
+	return &sessionStore{store: s, pool: pool}
+}
+
+type sessionStore struct {
+	store *Store
+	pool  string
+}
+
+func (ss *sessionStore) SaveSession(sess core.Session) error {
+	return ss.store.SaveSession(ss.pool, sess)
+}
+
+func (ss *sessionStore) DeleteSession(key string) error {
+	return ss.store.DeleteSession(ss.pool, key)
+}
+
+func (s *Store) SaveSession(pool string, sess core.Session) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO sessions (pool, key, proxy_url, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(pool, key) DO UPDATE SET
+			proxy_url = excluded.proxy_url,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at
+	`, pool, sess.Key, sess.ProxyURL, sess.CreatedAt, sess.ExpiresAt)
+	return err
+}
+
+func (s *Store) DeleteSession(pool, key string) error {
+	_, err := s.DB.Exec("DELETE FROM sessions WHERE pool = ? AND key = ?", pool, key)
+	return err
+}
+
+func (s *Store) LoadSessions(pool string) ([]core.Session, error) {
+	rows, err := s.DB.Query(`
+		SELECT key, proxy_url, created_at, expires_at
+		FROM sessions
+		WHERE pool = ?
+	`, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []core.Session
+	for rows.Next() {
+		var sess core.Session
+		if err := rows.Scan(&sess.Key, &sess.ProxyURL, &sess.CreatedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+
+	return sessions, rows.Err()
+}
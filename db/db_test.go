@@ -3,7 +3,11 @@ package db
 import (
 	"database/sql"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/nebojsaj1726/proxy-pool/core"
 )
 
 func TestInMemoryDB(t *testing.T) {
@@ -31,3 +35,152 @@ func TestInMemoryDB(t *testing.T) {
 		t.Fatalf("failed to ping db: %v", err)
 	}
 }
+
+// newTestStore migrates a fresh SQLite file in a temp dir.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	// Migrations are read from file://migrations, relative to the repo root.
+	t.Chdir("..")
+	s := ConnectAndMigrate()
+	t.Cleanup(func() { _ = s.DB.Close() })
+	return s
+}
+
+func newStoredProxy(url string) *core.Proxy {
+	return &core.Proxy{
+		URL:        url,
+		Score:      7,
+		UsageCount: 3,
+		Tags:       map[string]string{"country": "us"},
+		DomainScores: map[string]core.DomainScore{
+			"example.com": {Domain: "example.com", Score: 4, SuccessCount: 2, FailCount: 1, UpdatedAt: time.Now().UTC().Truncate(time.Second)},
+		},
+	}
+}
+
+func TestStore_ProxiesPerPool(t *testing.T) {
+	s := newTestStore(t)
+	const url = "http://10.0.0.1:8080"
+	if err := s.SaveProxy("a", newStoredProxy(url)); err != nil {
+		t.Fatal(err)
+	}
+	other := newStoredProxy(url)
+	other.Score = 2
+	other.DomainScores = nil
+	if err := s.SaveProxy("b", other); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.LoadProxies("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0].Score != 7 || a[0].UsageCount != 3 || a[0].Tags["country"] != "us" {
+		t.Fatalf("unexpected proxies in pool a: %+v", a)
+	}
+	if ds := a[0].DomainScores["example.com"]; ds.Score != 4 || ds.SuccessCount != 2 || ds.FailCount != 1 {
+		t.Fatalf("expected the domain score to round-trip, got %+v", a[0].DomainScores)
+	}
+
+	b, err := s.LoadProxies("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 || b[0].Score != 2 || len(b[0].DomainScores) != 0 {
+		t.Fatalf("expected pool b to keep its own row and no domain scores, got %+v", b)
+	}
+
+	if err := s.DeleteProxy("a", url); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := s.LoadProxies("a"); len(a) != 0 {
+		t.Fatalf("expected the proxy gone from pool a, got %+v", a)
+	}
+	if b, _ := s.LoadProxies("b"); len(b) != 1 {
+		t.Fatalf("expected pool b untouched by the delete, got %+v", b)
+	}
+}
+
+func TestStore_RetireProxy(t *testing.T) {
+	s := newTestStore(t)
+	expires := time.Date(2027, 1, 31, 18, 0, 0, 0, time.UTC)
+	retired := newStoredProxy("http://10.0.0.1:8080")
+	retired.ExpiresAt = expires
+	kept := newStoredProxy("http://10.0.0.2:8080")
+	for _, p := range []*core.Proxy{retired, kept} {
+		if err := s.SaveProxy("p", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := s.LoadProxies("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range loaded {
+		want := time.Time{}
+		if p.URL == retired.URL {
+			want = expires
+		}
+		if !p.ExpiresAt.Equal(want) {
+			t.Fatalf("expected expires_at %v for %s, got %v", want, p.URL, p.ExpiresAt)
+		}
+	}
+
+	if err := s.RetireProxy("p", retired.URL); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := s.LoadProxies("p"); len(loaded) != 1 || loaded[0].URL != kept.URL {
+		t.Fatalf("expected only the active proxy to load, got %+v", loaded)
+	}
+	got, err := s.RetiredProxy("p", retired.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.UsageCount != 3 || !got.ExpiresAt.Equal(expires) || got.DomainScores["example.com"].Score != 4 {
+		t.Fatalf("expected the retired row with its domain scores, got %+v", got)
+	}
+	if got, _ := s.RetiredProxy("p", kept.URL); got != nil {
+		t.Fatalf("expected no retired row for an active proxy, got %+v", got)
+	}
+
+	// Saving the URL again brings it back.
+	if err := s.SaveProxy("p", retired); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := s.LoadProxies("p"); len(loaded) != 2 {
+		t.Fatalf("expected the re-saved proxy to be active again, got %+v", loaded)
+	}
+}
+
+func TestStore_Sessions(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	sess := core.Session{Key: "k", ProxyURL: "http://10.0.0.1:8080", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := s.SaveSession("a", sess); err != nil {
+		t.Fatal(err)
+	}
+	sess.ProxyURL = "http://10.0.0.2:8080"
+	if err := s.SaveSession("b", sess); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.LoadSessions("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0].ProxyURL != "http://10.0.0.1:8080" || !a[0].ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected sessions in pool a: %+v", a)
+	}
+
+	if err := s.DeleteSession("a", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := s.LoadSessions("a"); len(a) != 0 {
+		t.Fatalf("expected the session gone from pool a, got %+v", a)
+	}
+	if b, _ := s.LoadSessions("b"); len(b) != 1 || b[0].ProxyURL != "http://10.0.0.2:8080" {
+		t.Fatalf("expected pool b's session untouched, got %+v", b)
+	}
+}
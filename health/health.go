@@ -8,24 +8,39 @@ import (
 	"github.com/nebojsaj1726/proxy-pool/db"
 )
 
+const defaultTimeout = 3 * time.Second
+
+// Manager runs the background checks of one pool. Name is the pool name
+// used for storage and log lines.
 type Manager struct {
-	Pool     core.Pooler
+	Name     string
+	Pool     core.Maintainer
 	Store    *db.Store
 	Interval time.Duration
+	Timeout  time.Duration
 	stopCh   chan struct{}
+	// warned holds the expiry each expiring proxy was last warned about,
+	// so the warning is logged once rather than on every check.
+	warned map[string]string
 }
 
-func New(pool core.Pooler, store *db.Store, interval time.Duration) *Manager {
+func New(name string, pool core.Maintainer, store *db.Store, interval, timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
 	return &Manager{
+		Name:     name,
 		Pool:     pool,
 		Store:    store,
 		Interval: interval,
+		Timeout:  timeout,
 		stopCh:   make(chan struct{}),
+		warned:   make(map[string]string),
 	}
 }
 
 func (m *Manager) Start() {
-	log.Printf("[health] starting background checks every %s", m.Interval)
+	log.Printf("[health] %s: starting background checks every %s", m.Name, m.Interval)
 	ticker := time.NewTicker(m.Interval)
 
 	go func() {
@@ -34,40 +49,66 @@ func (m *Manager) Start() {
 			select {
 			case <-ticker.C:
 				start := time.Now()
-				m.Pool.HealthCheck(3 * time.Second)
+				if n := m.Pool.ExpireLeases(); n > 0 {
+					log.Printf("[health] %s: reclaimed %d expired leases", m.Name, n)
+				}
+				if n := m.Pool.ExpireSessions(); n > 0 {
+					log.Printf("[health] %s: dropped %d idle sessions", m.Name, n)
+				}
+				m.Pool.HealthCheck(m.Timeout)
+				for _, ev := range m.Pool.UpdateTiers() {
+					log.Printf("[health] %s: tier %s %d -> %d (%s)", m.Name, ev.Kind, ev.From, ev.To, ev.Reason)
+				}
+				for _, pr := range m.Pool.RetireExpired() {
+					log.Printf("[health] %s: proxy %s expired at %s, moved out of the pool",
+						m.Name, pr.URL, pr.ExpiresAt.Format(time.RFC3339))
+				}
+				m.warnExpiring()
 
 				if m.Store != nil {
-					if pool, ok := m.Pool.(*core.Pool); ok {
-						for _, pr := range pool.Proxies {
-							if err := m.Store.SaveProxy(pr); err != nil {
-								log.Printf("[warn] failed to persist proxy %s: %v", pr.URL, err)
-							}
+					m.Pool.ForEach(func(pr *core.Proxy) {
+						if err := m.Store.SaveProxy(m.Name, pr); err != nil {
+							log.Printf("[warn] failed to persist proxy %s: %v", pr.URL, err)
 						}
-					}
+					})
 				}
 
-				alive := len(m.Pool.AliveProxies())
-				total := alive + countDead(m.Pool)
+				c := m.Pool.Counts()
 				duration := time.Since(start)
 
-				log.Printf("[health] check complete — alive: %d / %d, duration: %s", alive, total, duration)
+				log.Printf("[health] %s: check complete — alive: %d / %d (half-open: %d, probation: %d, expiring: %d), duration: %s",
+					m.Name, c.Alive, c.Total, c.HalfOpen, c.Probation, c.Expiring, duration)
 
 			case <-m.stopCh:
-				log.Println("[health] stopping background checks")
+				log.Printf("[health] %s: stopping background checks", m.Name)
 				return
 			}
 		}
 	}()
 }
 
-func (m *Manager) Stop() {
-	close(m.stopCh)
+// warnExpiring logs the proxies that entered the pool's expiry warning
+// window since the last check.
+func (m *Manager) warnExpiring() {
+	seen := make(map[string]bool)
+	for _, s := range m.Pool.Snapshots() {
+		if !s.Expiring {
+			continue
+		}
+		seen[s.URL] = true
+		if m.warned[s.URL] == s.ExpiresAt {
+			continue
+		}
+		m.warned[s.URL] = s.ExpiresAt
+		log.Printf("[warn] %s: proxy %s expires at %s", m.Name, s.URL, s.ExpiresAt)
+	}
+	for url := range m.warned {
+		if !seen[url] {
+			delete(m.warned, url)
+		}
+	}
 }
 
-func countDead(pool core.Pooler) int {
-	all := pool.AliveProxies()
-	if p, ok := pool.(*core.Pool); ok {
-		return len(p.Proxies) - len(all)
-	}
-	return 0
+func (m *Manager) Stop() {
+	close(m.stopCh)
 }
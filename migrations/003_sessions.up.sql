@@ -0,0 +1,6 @@
+CREATE TABLE sessions (
+    key TEXT PRIMARY KEY,
+    proxy_url TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
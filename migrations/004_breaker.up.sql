@@ -0,0 +1,5 @@
+ALTER TABLE proxies ADD COLUMN breaker_state TEXT NOT NULL DEFAULT 'closed';
+ALTER TABLE proxies ADD COLUMN state_changed_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE proxies ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0;
+UPDATE proxies SET state_changed_at = last_test;
+UPDATE proxies SET breaker_state = 'open' WHERE alive = 0;
@@ -0,0 +1 @@
+ALTER TABLE proxies ADD COLUMN tags TEXT NOT NULL DEFAULT '{}';
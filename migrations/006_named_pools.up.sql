@@ -0,0 +1,36 @@
+CREATE TABLE proxies_new (
+    pool TEXT NOT NULL DEFAULT 'default',
+    url TEXT NOT NULL,
+    score INTEGER NOT NULL,
+    alive BOOLEAN NOT NULL,
+    last_test TIMESTAMP NOT NULL,
+    usage_count INTEGER NOT NULL,
+    fail_count INTEGER NOT NULL,
+    success_count INTEGER NOT NULL,
+    latency_ms INTEGER,
+    breaker_state TEXT NOT NULL DEFAULT 'closed',
+    state_changed_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00',
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    tags TEXT NOT NULL DEFAULT '{}',
+    PRIMARY KEY (pool, url)
+);
+INSERT INTO proxies_new (url, score, alive, last_test, usage_count, fail_count, success_count, latency_ms,
+    breaker_state, state_changed_at, consecutive_failures, tags)
+SELECT url, score, alive, last_test, usage_count, fail_count, success_count, latency_ms,
+    breaker_state, state_changed_at, consecutive_failures, tags
+FROM proxies;
+DROP TABLE proxies;
+ALTER TABLE proxies_new RENAME TO proxies;
+
+CREATE TABLE sessions_new (
+    pool TEXT NOT NULL DEFAULT 'default',
+    key TEXT NOT NULL,
+    proxy_url TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (pool, key)
+);
+INSERT INTO sessions_new (key, proxy_url, created_at, expires_at)
+SELECT key, proxy_url, created_at, expires_at FROM sessions;
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
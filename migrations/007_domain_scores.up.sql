@@ -0,0 +1,10 @@
+CREATE TABLE proxy_domain_scores (
+    pool TEXT NOT NULL,
+    proxy_url TEXT NOT NULL,
+    domain TEXT NOT NULL,
+    score REAL NOT NULL,
+    success_count INTEGER NOT NULL,
+    fail_count INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (pool, proxy_url, domain)
+);
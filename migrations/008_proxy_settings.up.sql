@@ -0,0 +1,2 @@
+ALTER TABLE proxies ADD COLUMN max_concurrent INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxies ADD COLUMN rate_limit TEXT NOT NULL DEFAULT '{}';
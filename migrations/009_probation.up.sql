@@ -0,0 +1,2 @@
+ALTER TABLE proxies ADD COLUMN on_probation BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE proxies ADD COLUMN probation_passes INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE proxies ADD COLUMN tier INTEGER NOT NULL DEFAULT 0;
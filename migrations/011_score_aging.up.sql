@@ -0,0 +1,4 @@
+ALTER TABLE proxies ADD COLUMN scored_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE proxies SET scored_at = last_test;
+ALTER TABLE proxy_domain_scores ADD COLUMN aged_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE proxy_domain_scores SET aged_at = updated_at;
@@ -0,0 +1,2 @@
+ALTER TABLE proxies ADD COLUMN expires_at TIMESTAMP;
+ALTER TABLE proxies ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
@@ -6,12 +6,30 @@ export interface Proxy {
   last_test: string;
 }
 
+export interface LatencyStats {
+  samples: number;
+  ewma_ms: number;
+  p50_ms: number;
+  p90_ms: number;
+  p99_ms: number;
+  min_ms: number;
+  max_ms: number;
+  jitter_ms: number;
+}
+
 export interface ProxyStats extends Proxy {
+  id: string;
   score: number;
   usage_count: number;
   fail_count: number;
   success_count: number;
   latency_ms: number;
+  latency: LatencyStats;
+}
+
+export interface StatsSnapshot {
+  version: number;
+  proxies: ProxyStats[];
 }
 
 export const api = {
@@ -30,5 +48,6 @@ export const api = {
     apiFetch<{ allocated: string }>("/allocate", {
       method: "POST",
     }),
-  getStats: () => apiFetch<ProxyStats[]>("/proxies/stats"),
+  getStats: () =>
+    apiFetch<StatsSnapshot>("/proxies/stats").then((snap) => snap.proxies),
 };
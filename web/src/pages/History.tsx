@@ -27,7 +27,9 @@ export default function HistoryPage() {
                 <th className="px-4 py-2">Usage</th>
                 <th className="px-4 py-2">Success</th>
                 <th className="px-4 py-2">Fail</th>
-                <th className="px-4 py-2">Latency</th>
+                <th className="px-4 py-2">Latency (avg)</th>
+                <th className="px-4 py-2">p50 / p90 / p99</th>
+                <th className="px-4 py-2">Jitter</th>
                 <th className="px-4 py-2">Last Test</th>
                 <th className="px-4 py-2">Alive</th>
               </tr>
@@ -42,7 +44,21 @@ export default function HistoryPage() {
                     {s.success_count}
                   </td>
                   <td className="px-4 py-2 text-red-400">{s.fail_count}</td>
-                  <td className="px-4 py-2">{s.latency_ms} ms</td>
+                  <td className="px-4 py-2">
+                    {s.latency.samples > 0
+                      ? `${Math.round(s.latency.ewma_ms)} ms`
+                      : `${s.latency_ms} ms`}
+                  </td>
+                  <td className="px-4 py-2">
+                    {s.latency.samples > 0
+                      ? `${s.latency.p50_ms} / ${s.latency.p90_ms} / ${s.latency.p99_ms} ms`
+                      : "-"}
+                  </td>
+                  <td className="px-4 py-2">
+                    {s.latency.samples > 1
+                      ? `${Math.round(s.latency.jitter_ms)} ms`
+                      : "-"}
+                  </td>
                   <td className="px-4 py-2">{s.last_test}</td>
                   <td className="px-4 py-2">
                     <span